@@ -1,19 +1,22 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"database/sql"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
-	"mime"
-	"mime/multipart"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/mmcdole/gofeed/rss"
+	"unicode"
 )
 
 func removeFeed(w http.ResponseWriter, r *http.Request) {
@@ -28,8 +31,33 @@ func removeFeed(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 	}
 
-	url := parsed["url"][0]
-	removeFeedDb(url)
+	url := parsed.Get("url")
+	err = unsubscribeFeed(currentUser(r).Id, url)
+	if err != nil {
+		writeServiceError(w, r, "failed to remove feed", err)
+		return
+	}
+}
+
+func setFolder(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = setFeedFolder(currentUser(r).Id, parsed.Get("url"), parsed.Get("folder"))
+	if err != nil {
+		writeServiceError(w, r, "failed to move feed to folder", err)
+		return
+	}
 }
 
 func markRead(w http.ResponseWriter, r *http.Request) {
@@ -44,8 +72,21 @@ func markRead(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 	}
 
-	url := parsed["url"][0]
-	markReadDb(url)
+	userId := currentUser(r).Id
+	url := parsed.Get("url")
+	err = setArticleRead(userId, url, true)
+	if err != nil {
+		writeServiceError(w, r, "failed to mark article read", err)
+		return
+	}
+
+	session := undoSession(w, r)
+	err = recordDismissalDb(userId, session, url, "")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to record dismissal", "url", url, "err", err)
+		return
+	}
+	writeUndoToast(w, r, session, "Marked read")
 }
 
 func addTag(w http.ResponseWriter, r *http.Request) {
@@ -60,15 +101,20 @@ func addTag(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 	}
 
-	url := parsed["url"][0]
-	tag := parsed["tag"][0]
-	if tag[0] == '-' {
-		removeTagDb(url, tag[1:])
+	userId := currentUser(r).Id
+	url := parsed.Get("url")
+	tag := strings.TrimSpace(parsed.Get("tag"))
+	if strings.HasPrefix(tag, "-") {
+		err = untagArticle(userId, url, tag[1:])
 	} else {
-		addTagDb(url, tag)
+		err = tagArticle(userId, url, tag)
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to change article tags", err)
+		return
 	}
 
-	article := getArticleDb(url)
+	article := getArticleDb(userId, url)
 
 	articleComponentTemplate.Execute(w, article)
 }
@@ -85,62 +131,173 @@ func addTagMarkRead(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 	}
 
-	url := parsed["url"][0]
-	tag := parsed["tag"][0]
-	addTagDb(url, tag)
-	markReadDb(url)
+	userId := currentUser(r).Id
+	url := parsed.Get("url")
+	tag := parsed.Get("tag")
+	err = validTagName(tag)
+	if err == nil {
+		err = requireArticle(userId, url)
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to tag article", err)
+		return
+	}
+	session := undoSession(w, r)
+	// the dismissal has to be recorded first to know if the tag was already present
+	err = recordDismissalDb(userId, session, url, tag)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to record dismissal", "url", url, "err", err)
+	}
+	err = tagArticle(userId, url, tag)
+	if err == nil {
+		err = setArticleRead(userId, url, true)
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to tag article", err)
+		return
+	}
+
+	writeUndoToast(w, r, session, "Tagged "+tag)
 }
 
-func addFeed(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
+// Gets the id used to group the undo stack of a browser session, setting the cookie if there isn't one yet
+func undoSession(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie("undo_session")
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	session := rand.Text()
+	http.SetCookie(w, &http.Cookie{
+		Name:     "undo_session",
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session
+}
+
+type toast struct {
+	Message string
+	// The number of dismissals that can be undone in this session, 0 hides the undo buttons
+	Count int
+}
+
+func writeUndoToast(w http.ResponseWriter, r *http.Request, session string, message string) {
+	err := toastTemplate.Execute(w, toast{message, undoableDismissalsDb(currentUser(r).Id, session)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render undo toast", "err", err)
+	}
+}
+
+func undo(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
 	}
 	parsed, err := url.ParseQuery(string(body))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
 	}
 
-	url := parsed["url"][0]
+	count := 1
+	if parsed.Has("count") {
+		count, err = strconv.Atoi(parsed.Get("count"))
+		if err != nil || count < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid count"))
+			return
+		}
+	}
 
-	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
-		url = "https://" + url
+	undone, err := undoDismissalsDb(currentUser(r).Id, undoSession(w, r), count)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to undo dismissals", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
+	slog.DebugContext(r.Context(), "undid dismissals", "count", undone)
 
-	// the different paths to look for a feed in
-	// TODO: parallelize this
-	paths := []string{"", "/rss", "/index.xml", "/feed"}
+	// the restored articles need to be rendered back into the unread list
+	w.Header().Set("HX-Refresh", "true")
+}
 
-	for _, path := range paths {
-		slog.DebugContext(r.Context(), "trying path: "+string(url+path))
-		resp, err := http.Get(url + path)
-		if err == nil && resp.StatusCode == 200 {
-			slog.DebugContext(r.Context(), "got response on path: "+string(url+path))
-			parser := rss.Parser{}
-			_, err = parser.Parse(resp.Body)
-			if err != nil {
-				slog.DebugContext(r.Context(), "unable to read feed body")
-				continue
-			}
-			// this is the correct feed URL
-			err = addFeedDb(url + path)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
-			update_feed(db, url+path)
-			feed_template.Execute(w, getFeedDb(url+path))
-			break
-		} else if err != nil {
-			slog.DebugContext(r.Context(), "got err: %s status code: %d\n", err.Error(), resp.StatusCode)
-		} else {
-			slog.DebugContext(r.Context(), "got status code: %d\n", resp.StatusCode)
-		}
+func markUnread(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	url := parsed.Get("url")
+	err = setArticleRead(currentUser(r).Id, url, false)
+	if err != nil {
+		writeServiceError(w, r, "failed to mark article unread", err)
+		return
+	}
+}
+
+func restore(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	id, err := strconv.ParseInt(parsed.Get("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid id"))
+		return
+	}
+
+	err = restoreDismissalDb(currentUser(r).Id, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to restore dismissal", "id", id, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+func addFeed(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+
+	added, err := subscribeFeed(currentUser(r).Id, parsed.Get("url"))
+	if err != nil {
+		writeServiceError(w, r, "failed to add feed", err)
+		return
+	}
+	feed_template.Execute(w, added)
 	end := time.Now()
 	slog.DebugContext(r.Context(), "ran /add/feed in "+end.Sub(start).String())
 }
@@ -157,9 +314,13 @@ func searchQuery(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 	}
 
-	query := parsed["query"][0]
+	query := parsed.Get("query")
 
-	articleList := queryArticlesDb(query)
+	articleList, _, err := searchArticles(currentUser(r).Id, query, 1, math.MaxInt32)
+	if err != nil {
+		writeServiceError(w, r, "failed to search", err)
+		return
+	}
 
 	searchResultsTemplate.Execute(w, articleList)
 }
@@ -180,47 +341,52 @@ func addBookmark(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := parsed["url"][0]
-
-	slog.DebugContext(r.Context(), "adding bookmark", "url", url)
-
-	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
-		url = "https://" + url
+	_, _, err = addBookmarkUrl(currentUser(r).Id, parsed.Get("url"))
+	if err != nil {
+		writeServiceError(w, r, "failed to add bookmark", err)
+		return
 	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		slog.ErrorContext(r.Context(), "failed to get bookmark website", "url", url, "err", err.Error())
+	w.Write([]byte("Bookmark added successfully"))
+}
+
+// Saves a page from the page the bookmarklet opens, with the HTML the browser had if the bookmarklet could send it
+func saveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	// the page's HTML from the browser is held to the same limit as pages we fetch ourselves
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPageSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "the page is larger than %d bytes", tooLarge.Limit)
+		return
+	} else if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	httpBody, err := io.ReadAll(resp.Body)
+	parsed, err := url.ParseQuery(string(body))
 	if err != nil {
-		slog.ErrorContext(r.Context(), "failed to read response body")
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	regex := regexp.MustCompile(`<title[^<>]*>([^<>]*)<\/title>`)
-
-	matches := regex.FindSubmatch(httpBody)
-	title := string(matches[1])
-
-	slog.DebugContext(r.Context(), "extracted from bookmark", "title", title)
-
-	article := Article{
-		Url:        url,
-		EscapedUrl: "",
-		Title:      title,
-		Date:       time.Now().Format(time.RFC3339),
-		Comments:   []Comments{},
-		Tags:       []string{"bookmark"},
+	var html []byte
+	if parsed.Get("html") != "" {
+		html = []byte(parsed.Get("html"))
+	}
+	tags := strings.FieldsFunc(parsed.Get("tags"), func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	_, added, err := saveBookmark(currentUser(r).Id, parsed.Get("url"), parsed.Get("title"), html, tags, parsed.Get("note"))
+	if err != nil {
+		writeServiceError(w, r, "failed to save bookmark", err)
+		return
 	}
-	addArticleDb(article)
 
-	w.Write([]byte("Bookmark added successfully"))
+	if added {
+		w.Write([]byte("Bookmark saved"))
+	} else {
+		w.Write([]byte("Bookmark updated"))
+	}
 }
 
 func unreadHandler(w http.ResponseWriter, r *http.Request) {
@@ -229,10 +395,11 @@ func unreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	articleList := unreadArticlesDb(10)
+	userId := currentUser(r).Id
+	articleList := unreadArticlesDb(userId, 10)
 
 	articles := Articles{
-		FavoriteTags: []string{"later", "favorite", "reference", "archive"},
+		FavoriteTags: favoriteTagsDb(userId),
 		Articles:     articleList,
 	}
 
@@ -249,7 +416,7 @@ func feedsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	feeds := feedsDb()
+	feeds := feedsDb(currentUser(r).Id)
 
 	err := feedsTemplate.Execute(w, feeds)
 	if err != nil {
@@ -263,9 +430,78 @@ func articleHandler(w http.ResponseWriter, r *http.Request) {
 	if article_url == "" {
 		panic("couldn't get article out of path")
 	}
-	article := getArticleDb(article_url)
+	err := requireArticle(currentUser(r).Id, article_url)
+	if err != nil {
+		writeServiceError(w, r, "failed to get article", err)
+		return
+	}
+	page := ArticlePage{Article: getArticleDb(currentUser(r).Id, article_url)}
+	page.Archive, page.DeadLink = archivedCopyDb(article_url)
+	page.Link = linkHealthDb(article_url)
 
-	articleTemplate.Execute(w, article)
+	page.View = r.URL.Query().Get("view")
+	if page.View != "reader" && page.View != "details" && page.View != "versions" {
+		// the original is gone so the archive is the only useful thing to show
+		if page.DeadLink && page.Archive != nil {
+			page.View = "reader"
+		} else {
+			page.View = "details"
+		}
+	}
+
+	switch page.View {
+	case "reader":
+		// an older version can be read instead of the latest one
+		version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+		if err == nil && page.Archive != nil {
+			contents, captured, err := archiveVersionDb(article_url, version)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to get archive version", "url", article_url, "err", err)
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			err = page.Archive.setContents(contents)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			page.Archive.CapturedAt = captured.Format(time.RFC1123)
+		}
+	case "versions":
+		page.Versions = archiveVersionsDb(article_url)
+		from, fromErr := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, toErr := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		if fromErr == nil && toErr == nil {
+			before, _, err := archiveVersionDb(article_url, from)
+			if err == nil {
+				var after string
+				after, _, err = archiveVersionDb(article_url, to)
+				page.Diff = diffLines(before, after)
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to get archive versions", "url", article_url, "err", err)
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			for i := range page.Versions {
+				if page.Versions[i].Id == from {
+					page.From = &page.Versions[i]
+				}
+				if page.Versions[i].Id == to {
+					page.To = &page.Versions[i]
+				}
+			}
+		}
+	}
+
+	err = articleTemplate.Execute(w, page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
 }
 
 func searchHandler(w http.ResponseWriter, r *http.Request) {
@@ -274,9 +510,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	articleList := readArticlesDb(20)
-
-	err := search_template.Execute(w, articleList)
+	err := search_template.Execute(w, nil)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
@@ -289,84 +523,499 @@ func bookmarkHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := bookmark_template.Execute(w, nil)
+	origin := "http://" + r.Host
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		origin = "https://" + r.Host
+	}
+	err := bookmark_template.Execute(w, BookmarkPage{Bookmarklet: bookmarklet(origin)})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// Opens this server's save page for the current page, with the text selected on it as the note. Once the save page is
+// ready it is sent the page's HTML, so the page doesn't have to be fetched again (see save.html).
+func bookmarklet(origin string) template.URL {
+	return template.URL("javascript:(()=>{const o=" + strconv.Quote(origin) + ",h=document.documentElement.outerHTML," +
+		"w=open(o+'/save?'+new URLSearchParams({url:location.href,title:document.title,note:String(getSelection())}),'_blank','popup,width=640,height=640');" +
+		"addEventListener('message',e=>{if(e.source===w&&e.data==='naarum:ready')w.postMessage({html:h},o)})})()")
+}
+
+// The page the bookmarklet opens, which shows what will be saved so it can be changed before saving.
+// It doesn't save anything itself so that other sites can't add bookmarks by linking to it.
+func saveHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := SavePage{
+		Url:   query.Get("url"),
+		Title: query.Get("title"),
+		Tags:  query.Get("tags"),
+		Note:  query.Get("note"),
+	}
+	err := saveTemplate.Execute(w, page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+const historyPageSize = 50
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		println("unexpected method")
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// get one extra entry to know if there is a next page
+	entries := historyDb(currentUser(r).Id, historyPageSize+1, (page-1)*historyPageSize)
+	history := History{
+		Entries:  entries,
+		Page:     page,
+		Previous: page - 1,
+	}
+	if len(entries) > historyPageSize {
+		history.Entries = entries[:historyPageSize]
+		history.Next = page + 1
+	}
+
+	err = historyTemplate.Execute(w, history)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 	}
 }
 
-type Bookmark struct {
-	URI   string `json:"uri"`
-	Title string `json:"title"`
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		println("unexpected method")
+		return
+	}
+
+	err := tagsTemplate.Execute(w, tagsDb(currentUser(r).Id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
 }
 
-type BookmarkFolder struct {
-	Children []Bookmark `json:"children"`
+const taggedArticlesPageSize = 20
+
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// get one extra article to know if there is a next page
+	articleList := taggedArticlesDb(currentUser(r).Id, tag, taggedArticlesPageSize+1, (page-1)*taggedArticlesPageSize)
+	tagged := TaggedArticles{
+		Tag:      tag,
+		Articles: articleList,
+		Page:     page,
+		Previous: page - 1,
+	}
+	if len(articleList) > taggedArticlesPageSize {
+		tagged.Articles = articleList[:taggedArticlesPageSize]
+		tagged.Next = page + 1
+	}
+
+	err = tagTemplate.Execute(w, tagged)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
 }
 
-type Bookmarks struct {
-	Guid     string           `json:"guid"`
-	Children []BookmarkFolder `json:"children"`
+// Runs an action on every selected article, responding with a toast saying how many were changed
+func bulk(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	action := r.PathValue("action")
+	tag := strings.TrimSpace(parsed.Get("tag"))
+	if (action == "add_tag" || action == "retag") && !tagNameRegex.MatchString(tag) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid tag name: %q", tag)))
+		return
+	}
+	urls := parsed["url"]
+
+	changed, err := bulkDb(currentUser(r).Id, urls, action, tag, parsed.Get("from"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to run bulk action", "action", action, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	slog.DebugContext(r.Context(), "ran bulk action", "action", action, "tag", tag, "selected", len(urls), "changed", changed)
+
+	if action == "add_tag" || action == "retag" {
+		for _, url := range urls {
+			apply_archive_policy(db, url)
+		}
+	}
+	if action == "archive" {
+		for _, url := range urls {
+			_, err = queue_job(db, "archive", url)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to queue article to be archived", "url", url, "err", err)
+			}
+		}
+	}
+
+	err = toastTemplate.Execute(w, toast{Message: fmt.Sprintf("%s: %d of %d articles changed", strings.ReplaceAll(action, "_", " "), changed, len(urls))})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render toast", "err", err)
+	}
 }
 
-func importBookmarks(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("importing bookmarks")
-	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+// Tags can't start with - or # since those are used to remove tags and search for them
+var tagNameRegex = regexp.MustCompile(`^[^\s#-]\S*$`)
+
+// Handles the tag management actions, responding with the updated list of tags
+func editTag(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.ErrorContext(r.Context(), "request lacks Content-Type header", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
 	}
-	mr := multipart.NewReader(r.Body, params["boundary"])
-	// 4MiB should be enough TODO: make this configurable
-	form, err := mr.ReadForm(4 * 1024 * 1024)
+	parsed, err := url.ParseQuery(string(body))
 	if err != nil {
-		slog.ErrorContext(r.Context(), "error parsing form", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
+	}
+
+	userId := currentUser(r).Id
+	name := strings.TrimSpace(parsed.Get("name"))
+	target := strings.TrimSpace(parsed.Get("target"))
+
+	switch r.PathValue("action") {
+	case "create":
+		err = createTag(userId, name, parsed.Get("favorite") == "on")
+	case "rename":
+		err = renameTag(userId, name, target)
+	case "merge":
+		err = mergeTag(userId, name, target)
+	case "delete":
+		err = deleteTag(userId, name)
+	case "favorite":
+		err = setTagFavorite(userId, name, parsed.Get("favorite") == "true")
+	case "up":
+		err = moveTagDb(userId, name, -1)
+	case "down":
+		err = moveTagDb(userId, name, 1)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown tag action"))
+		return
 	}
-	fileHeaders := form.File["file"]
-	fmt.Println(len(fileHeaders))
-	file, err := fileHeaders[0].Open()
 	if err != nil {
-		slog.ErrorContext(r.Context(), "error opening form file", "err", err)
+		writeServiceError(w, r, "failed to edit tag", err)
+		return
+	}
+
+	err = tagsTemplate.ExecuteTemplate(w, "tag-list.html", tagsDb(userId))
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 	}
-	bytes, err := io.ReadAll(file)
+}
+
+// Queues an article to be archived right away, responding with its progress
+func archiveNow(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.ErrorContext(r.Context(), "error opening form file", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	var bookmarks Bookmarks
-	err = json.Unmarshal(bytes, &bookmarks)
+	article_url := parsed.Get("url")
+	if article_url == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing url"))
+		return
+	}
+	err = requireArticle(currentUser(r).Id, article_url)
+	if err != nil {
+		writeServiceError(w, r, "failed to archive article", err)
+		return
+	}
+	id, err := queue_job(db, "archive", article_url)
 	if err != nil {
-		slog.Error("error opening form file", "err", err)
+		slog.ErrorContext(r.Context(), "failed to queue article to be archived", "url", article_url, "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
+		return
 	}
+	writeJobStatus(w, r, id)
+}
 
-	slog.Debug("parsed bookmarks", "folders", len(bookmarks.Children), "guid", bookmarks.Guid)
+// Responds with the progress of a job, polled by the "Archive now" button
+func jobStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid id"))
+		return
+	}
+	writeJobStatus(w, r, id)
+}
 
-	for _, folder := range bookmarks.Children {
-		slog.Debug("parsed bookmarks folder", "bookmarks", len(folder.Children))
-		for _, bookmark := range folder.Children {
-			slog.Debug("got bookmark", "bookmark", bookmark)
-			if strings.HasPrefix(bookmark.URI, "http") {
-				article := Article{
-					Url:        bookmark.URI,
-					EscapedUrl: "",
-					Title:      bookmark.Title,
-					Date:       time.Now().Format(time.RFC3339),
-					Comments:   []Comments{},
-					Tags:       []string{"bookmark"},
-				}
-				addArticleDb(article)
-			}
+func retryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid id"))
+		return
+	}
+	err = retryJobDb(currentUser(r).Id, id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to retry job", "id", id, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	wake_jobs()
+	writeJobStatus(w, r, id)
+}
+
+func writeJobStatus(w http.ResponseWriter, r *http.Request, id int64) {
+	job, err := jobDb(currentUser(r).Id, id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404"))
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get job", "id", id, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = jobStatusTemplate.Execute(w, job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// How many jobs are listed for each queue on the jobs page
+const jobsPageSize = 100
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	err := jobsTemplate.Execute(w, jobQueuesDb(currentUser(r).Id, jobsPageSize))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	settings := Settings{User: user, Rules: archiveRulesDb(), Feeds: feedUrlsDb()}
+	for _, tag := range tagsDb(user.Id) {
+		settings.Tags = append(settings.Tags, tag.Name)
+	}
+	if user.Admin {
+		var err error
+		settings.Users, err = usersDb()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get users", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
 		}
 	}
+	err := settingsTemplate.Execute(w, settings)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// Responds with 403 unless an admin is logged in, returns whether they are
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if currentUser(r).Admin {
+		return true
+	}
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("only admins can do that"))
+	return false
+}
 
+// Adds an account, responding with the updated list of accounts
+func addUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = createUser(parsed.Get("username"), parsed.Get("password"), parsed.Get("admin") == "on")
+	if err != nil {
+		writeServiceError(w, r, "failed to add user", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "added user", "user", parsed.Get("username"), "by", currentUser(r).Name)
+
+	users, err := usersDb()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = settingsTemplate.ExecuteTemplate(w, "users.html", users)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// Handles adding, removing and reordering archive rules, responding with the updated list of rules.
+// The rules apply to everyone's articles, so only admins can change them.
+func editArchiveRule(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	id, _ := strconv.ParseInt(parsed.Get("id"), 10, 64)
+	switch r.PathValue("action") {
+	case "add":
+		var rule ArchiveRule
+		rule, err = archiveRuleFromForm(parsed)
+		if err != nil {
+			break
+		}
+		err = addArchiveRuleDb(rule)
+	case "delete":
+		err = deleteArchiveRuleDb(id)
+	case "up":
+		err = moveArchiveRuleDb(id, -1)
+	case "down":
+		err = moveArchiveRuleDb(id, 1)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown archive rule action"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to edit archive rule", "action", r.PathValue("action"), "id", id, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = settingsTemplate.ExecuteTemplate(w, "archive-rules.html", archiveRulesDb())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+func archiveRuleFromForm(form url.Values) (ArchiveRule, error) {
+	rule := ArchiveRule{
+		Match:  form.Get("match"),
+		Value:  strings.TrimSpace(form.Get("value")),
+		Action: form.Get("action"),
+	}
+	if !slices.Contains(archiveRuleMatches, rule.Match) {
+		return rule, fmt.Errorf("invalid archive rule match: %q", rule.Match)
+	}
+	if !slices.Contains(archiveRuleActions, rule.Action) {
+		return rule, fmt.Errorf("invalid archive rule action: %q", rule.Action)
+	}
+
+	switch rule.Match {
+	case "tagged", "all":
+		rule.Value = ""
+	case "tag":
+		rule.Value = strings.TrimPrefix(rule.Value, "#")
+		if !tagNameRegex.MatchString(rule.Value) {
+			return rule, fmt.Errorf("invalid tag name: %q", rule.Value)
+		}
+	case "feed":
+		if rule.Value == "" {
+			return rule, fmt.Errorf("missing feed URL")
+		}
+	case "domain":
+		// a full URL can be pasted in instead of just the domain
+		domain := strings.ToLower(rule.Value)
+		if parsed, err := url.Parse(domain); err == nil && parsed.Host != "" {
+			domain = parsed.Hostname()
+		}
+		rule.Value = strings.TrimPrefix(domain, "www.")
+		if rule.Value == "" || strings.ContainsAny(rule.Value, "/ ") {
+			return rule, fmt.Errorf("invalid domain: %q", form.Get("value"))
+		}
+	}
+
+	if maxSize := strings.TrimSpace(form.Get("max_size")); maxSize != "" {
+		megabytes, err := strconv.ParseFloat(maxSize, 64)
+		if err != nil || megabytes < 0 {
+			return rule, fmt.Errorf("invalid max size: %q", maxSize)
+		}
+		rule.MaxSize = int64(megabytes * 1000 * 1000)
+	}
+	return rule, nil
+}
+
+// Responds with the error from a service function, with a status depending on what went wrong
+func writeServiceError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	slog.ErrorContext(r.Context(), message, "err", err)
+	switch {
+	case errors.Is(err, errNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, errInvalid):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(err.Error()))
 }
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The JSON API under /api/v1, described by static/openapi.json. It uses the same service functions as the htmx handlers.
+
+const defaultApiPageSize = 50
+const maxApiPageSize = 500
+
+type apiArticle struct {
+	Url   string `json:"url"`
+	Title string `json:"title"`
+	// RFC 3339, empty if the article doesn't have a date
+	Published string        `json:"published"`
+	Read      bool          `json:"read"`
+	Tags      []string      `json:"tags"`
+	Comments  []apiComments `json:"comments"`
+}
+
+type apiComments struct {
+	Url  string `json:"url"`
+	Feed string `json:"feed"`
+}
+
+type apiFeed struct {
+	Url         string `json:"url"`
+	SiteUrl     string `json:"site_url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type apiTag struct {
+	Name     string `json:"name"`
+	Favorite bool   `json:"favorite"`
+	Articles int    `json:"articles"`
+	Unread   int    `json:"unread"`
+}
+
+type apiPage[T any] struct {
+	Items   []T `json:"items"`
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	// null on the last page
+	NextPage *int `json:"next_page"`
+}
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	// One of "invalid_request", "not_found" or "internal"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func toApiArticle(article Article) apiArticle {
+	converted := apiArticle{
+		Url:      article.Url,
+		Title:    article.Title,
+		Read:     article.Read,
+		Tags:     article.Tags,
+		Comments: []apiComments{},
+	}
+	if converted.Tags == nil {
+		converted.Tags = []string{}
+	}
+	if date, err := time.Parse(time.RFC1123, article.Date); err == nil {
+		converted.Published = date.Format(time.RFC3339)
+	}
+	for _, comments := range article.Comments {
+		converted.Comments = append(converted.Comments, apiComments{comments.Url, comments.Feed})
+	}
+	return converted
+}
+
+func toApiArticles(articles []Article) []apiArticle {
+	converted := []apiArticle{}
+	for _, article := range articles {
+		converted = append(converted, toApiArticle(article))
+	}
+	return converted
+}
+
+func toApiFeed(f feed) apiFeed {
+	return apiFeed{Url: f.FeedUrl, SiteUrl: f.SiteUrl, Title: f.Title, Description: f.Description}
+}
+
+// Wraps a JSON API handler, turning returned errors and panics into error objects
+func apiV1(handler func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				slog.ErrorContext(r.Context(), "panic in API handler", "path", r.URL.Path, "err", recovered)
+				writeApiError(w, fmt.Errorf("%v", recovered))
+			}
+		}()
+		err := handler(w, r)
+		if err != nil {
+			if errors.Is(err, errNotFound) || errors.Is(err, errInvalid) {
+				slog.InfoContext(r.Context(), "bad API request", "method", r.Method, "path", r.URL.Path, "err", err)
+			} else {
+				slog.ErrorContext(r.Context(), "API request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+			writeApiError(w, err)
+		}
+	}
+}
+
+func writeApiError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, "internal"
+	switch {
+	case errors.Is(err, errNotFound):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.Is(err, errInvalid):
+		status, code = http.StatusBadRequest, "invalid_request"
+	}
+	writeJson(w, status, apiError{apiErrorBody{code, err.Error()}})
+}
+
+func writeJson(w http.ResponseWriter, status int, value any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(value)
+}
+
+func readJson(r *http.Request, value any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1024*1024))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err != nil {
+		return fmt.Errorf("%w: invalid JSON body: %v", errInvalid, err)
+	}
+	return nil
+}
+
+// Gets the page and per_page query parameters
+func apiPagination(r *http.Request) (int, int, error) {
+	page, perPage := 1, defaultApiPageSize
+	var err error
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("%w: page must be a positive integer", errInvalid)
+		}
+	}
+	if value := r.URL.Query().Get("per_page"); value != "" {
+		perPage, err = strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxApiPageSize {
+			return 0, 0, fmt.Errorf("%w: per_page must be between 1 and %d", errInvalid, maxApiPageSize)
+		}
+	}
+	return page, perPage, nil
+}
+
+func newApiPage[T any](items []T, page int, perPage int, more bool) apiPage[T] {
+	result := apiPage[T]{Items: items, Page: page, PerPage: perPage}
+	if more {
+		next := page + 1
+		result.NextPage = &next
+	}
+	return result
+}
+
+func apiListFeeds(w http.ResponseWriter, r *http.Request) error {
+	feeds := []apiFeed{}
+	for _, f := range feedsDb(currentUser(r).Id) {
+		feeds = append(feeds, toApiFeed(f))
+	}
+	return writeJson(w, http.StatusOK, feeds)
+}
+
+func apiAddFeed(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Url string `json:"url"`
+	}
+	err := readJson(r, &request)
+	if err != nil {
+		return err
+	}
+	added, err := subscribeFeed(currentUser(r).Id, request.Url)
+	if err != nil {
+		return err
+	}
+	return writeJson(w, http.StatusCreated, toApiFeed(added))
+}
+
+func apiRemoveFeed(w http.ResponseWriter, r *http.Request) error {
+	err := unsubscribeFeed(currentUser(r).Id, r.PathValue("url"))
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func apiListArticles(w http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPagination(r)
+	if err != nil {
+		return err
+	}
+	unread := false
+	if value := r.URL.Query().Get("unread"); value != "" {
+		unread, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%w: unread must be true or false", errInvalid)
+		}
+	}
+	articles, more := listArticles(currentUser(r).Id, unread, r.URL.Query().Get("tag"), page, perPage)
+	return writeJson(w, http.StatusOK, newApiPage(toApiArticles(articles), page, perPage, more))
+}
+
+func apiGetArticle(w http.ResponseWriter, r *http.Request) error {
+	article, err := getArticle(currentUser(r).Id, r.PathValue("url"))
+	if err != nil {
+		return err
+	}
+	return writeJson(w, http.StatusOK, toApiArticle(article))
+}
+
+// PUT marks the article read, DELETE marks it unread
+func apiSetRead(w http.ResponseWriter, r *http.Request) error {
+	article_url := r.PathValue("url")
+	err := setArticleRead(currentUser(r).Id, article_url, r.Method == http.MethodPut)
+	if err != nil {
+		return err
+	}
+	return apiGetArticle(w, r)
+}
+
+// PUT adds the tag to the article, DELETE removes it
+func apiSetTag(w http.ResponseWriter, r *http.Request) error {
+	article_url, tag := r.PathValue("url"), r.PathValue("tag")
+	var err error
+	if r.Method == http.MethodPut {
+		err = tagArticle(currentUser(r).Id, article_url, tag)
+	} else {
+		err = untagArticle(currentUser(r).Id, article_url, tag)
+	}
+	if err != nil {
+		return err
+	}
+	return apiGetArticle(w, r)
+}
+
+func apiSearch(w http.ResponseWriter, r *http.Request) error {
+	page, perPage, err := apiPagination(r)
+	if err != nil {
+		return err
+	}
+	articles, more, err := searchArticles(currentUser(r).Id, r.URL.Query().Get("q"), page, perPage)
+	if err != nil {
+		return err
+	}
+	return writeJson(w, http.StatusOK, newApiPage(toApiArticles(articles), page, perPage, more))
+}
+
+func apiListTags(w http.ResponseWriter, r *http.Request) error {
+	tags := []apiTag{}
+	for _, tag := range tagsDb(currentUser(r).Id) {
+		tags = append(tags, apiTag{Name: tag.Name, Favorite: tag.Favorite, Articles: tag.Count, Unread: tag.Unread})
+	}
+	return writeJson(w, http.StatusOK, tags)
+}
+
+func apiGetTag(w http.ResponseWriter, r *http.Request, name string, status int) error {
+	for _, tag := range tagsDb(currentUser(r).Id) {
+		if tag.Name == name {
+			return writeJson(w, status, apiTag{Name: tag.Name, Favorite: tag.Favorite, Articles: tag.Count, Unread: tag.Unread})
+		}
+	}
+	return fmt.Errorf("%w: tag %s", errNotFound, name)
+}
+
+func apiCreateTag(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Name     string `json:"name"`
+		Favorite bool   `json:"favorite"`
+	}
+	err := readJson(r, &request)
+	if err != nil {
+		return err
+	}
+	err = createTag(currentUser(r).Id, request.Name, request.Favorite)
+	if err != nil {
+		return err
+	}
+	return apiGetTag(w, r, request.Name, http.StatusCreated)
+}
+
+// Renames a tag and/or changes whether it is a favorite
+func apiUpdateTag(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Name     *string `json:"name"`
+		Favorite *bool   `json:"favorite"`
+	}
+	err := readJson(r, &request)
+	if err != nil {
+		return err
+	}
+	name := r.PathValue("name")
+	if request.Favorite != nil {
+		err = setTagFavorite(currentUser(r).Id, name, *request.Favorite)
+		if err != nil {
+			return err
+		}
+	}
+	if request.Name != nil && *request.Name != name {
+		err = renameTag(currentUser(r).Id, name, *request.Name)
+		if err != nil {
+			return err
+		}
+		name = *request.Name
+	}
+	return apiGetTag(w, r, name, http.StatusOK)
+}
+
+func apiMergeTag(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Into string `json:"into"`
+	}
+	err := readJson(r, &request)
+	if err != nil {
+		return err
+	}
+	err = mergeTag(currentUser(r).Id, r.PathValue("name"), request.Into)
+	if err != nil {
+		return err
+	}
+	return apiGetTag(w, r, request.Into, http.StatusOK)
+}
+
+func apiDeleteTag(w http.ResponseWriter, r *http.Request) error {
+	err := deleteTag(currentUser(r).Id, r.PathValue("name"))
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Responds 201 with the new bookmark, or 200 if the page was already saved
+func apiAddBookmark(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Url string `json:"url"`
+	}
+	err := readJson(r, &request)
+	if err != nil {
+		return err
+	}
+	article, added, err := addBookmarkUrl(currentUser(r).Id, request.Url)
+	if err != nil {
+		return err
+	}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	return writeJson(w, status, toApiArticle(article))
+}
+
+func apiOpenApi(w http.ResponseWriter, r *http.Request) {
+	document, err := static.ReadFile("static/openapi.json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(document)
+}
+
+func registerApiV1(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/openapi.json", apiOpenApi)
+
+	mux.HandleFunc("GET /api/v1/feeds", apiV1(apiListFeeds))
+	mux.HandleFunc("POST /api/v1/feeds", apiV1(apiAddFeed))
+	mux.HandleFunc("DELETE /api/v1/feeds/{url}", apiV1(apiRemoveFeed))
+
+	mux.HandleFunc("GET /api/v1/articles", apiV1(apiListArticles))
+	mux.HandleFunc("GET /api/v1/articles/{url}", apiV1(apiGetArticle))
+	mux.HandleFunc("PUT /api/v1/articles/{url}/read", apiV1(apiSetRead))
+	mux.HandleFunc("DELETE /api/v1/articles/{url}/read", apiV1(apiSetRead))
+	mux.HandleFunc("PUT /api/v1/articles/{url}/tags/{tag}", apiV1(apiSetTag))
+	mux.HandleFunc("DELETE /api/v1/articles/{url}/tags/{tag}", apiV1(apiSetTag))
+
+	mux.HandleFunc("GET /api/v1/search", apiV1(apiSearch))
+
+	mux.HandleFunc("GET /api/v1/tags", apiV1(apiListTags))
+	mux.HandleFunc("POST /api/v1/tags", apiV1(apiCreateTag))
+	mux.HandleFunc("PATCH /api/v1/tags/{name}", apiV1(apiUpdateTag))
+	mux.HandleFunc("POST /api/v1/tags/{name}/merge", apiV1(apiMergeTag))
+	mux.HandleFunc("DELETE /api/v1/tags/{name}", apiV1(apiDeleteTag))
+
+	mux.HandleFunc("POST /api/v1/bookmarks", apiV1(apiAddBookmark))
+
+	mux.HandleFunc("/api/v1/", apiV1(func(w http.ResponseWriter, r *http.Request) error {
+		return fmt.Errorf("%w: no API endpoint %s %s", errNotFound, r.Method, r.URL.Path)
+	}))
+}
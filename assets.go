@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Limits on the images downloaded for a single archived article
+const maxAssetsPerArticle = 30
+const maxAssetSize = 5 * 1024 * 1024
+
+// Matches the targets of markdown images, ie ![alt](https://example.com/image.png "title")
+var markdownImageRegex = regexp.MustCompile(`!\[[^\]]*\]\(<?([^)\s>]+)>?`)
+
+// Downloads the images referenced by an archive and rewrites them to point at our copies.
+// Images that can't be downloaded keep pointing at the original.
+func archiveAssets(db *sql.DB, markdown string) string {
+	downloaded := map[string]string{}
+	return markdownImageRegex.ReplaceAllStringFunc(markdown, func(image string) string {
+		source := markdownImageRegex.FindStringSubmatch(image)[1]
+		local, ok := downloaded[source]
+		if !ok {
+			if len(downloaded) >= maxAssetsPerArticle {
+				return image
+			}
+			local = archiveAsset(db, source)
+			downloaded[source] = local
+		}
+		if local == "" {
+			return image
+		}
+		return strings.Replace(image, source, local, 1)
+	})
+}
+
+// Downloads a single image and returns its local path, or an empty string if it can't be archived
+func archiveAsset(db *sql.DB, source string) string {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		return ""
+	}
+
+	// the same image is often used by many articles, or was imported from a WARC file
+	var hash string
+	err := db.QueryRow("SELECT hash FROM assets WHERE source=?", source).Scan(&hash)
+	if err == nil {
+		return "/assets/" + hash
+	}
+
+	resp, err := httpClient.Get(source)
+	if err != nil {
+		slog.Error("unable to get asset", "url", source, "error", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("unable to get asset", "url", source, "status", resp.StatusCode)
+		return ""
+	}
+
+	content_type, _, err := mime.ParseMediaType(resp.Header.Get("content-type"))
+	if err != nil || !strings.HasPrefix(content_type, "image/") {
+		slog.Error("asset is not an image, not archiving", "url", source, "content-type", resp.Header.Get("content-type"))
+		return ""
+	}
+	if resp.ContentLength > maxAssetSize {
+		slog.Error("asset is too large, not archiving", "url", source, "size", resp.ContentLength)
+		return ""
+	}
+
+	data, err := readLimited(resp.Body, maxAssetSize)
+	if err != nil {
+		slog.Error("unable to read asset", "url", source, "error", err)
+		return ""
+	}
+
+	hash, err = addAssetDb(db, data, content_type, source)
+	if err != nil {
+		slog.Error("unable to add asset to db", "url", source, "error", err)
+		return ""
+	}
+	return "/assets/" + hash
+}
+
+// Stores a file by the hash of its contents and returns the hash. The dimensions of images are recorded when they can be decoded.
+func addAssetDb(db *sql.DB, data []byte, content_type string, source string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	var width, height any
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = config.Width, config.Height
+	}
+	_, err := db.Exec("INSERT OR IGNORE INTO assets (hash, content_type, data, source, created, width, height) VALUES (?, ?, ?, ?, current_localtimestamp(), ?, ?)",
+		hash, content_type, data, source, width, height)
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func assetHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+
+	var content_type string
+	var data []byte
+	err := db.QueryRow("SELECT content_type, data FROM assets WHERE hash=?", hash).Scan(&content_type, &data)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404"))
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "unable to get asset", "hash", hash, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", content_type)
+	// the contents of a hash can never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf("%q", hash))
+	// archived files come from other websites and shouldn't be able to run anything on ours.
+	// Browsers refuse to show sandboxed PDFs, and their viewers don't run the PDF's scripts anyway.
+	if content_type == "application/pdf" {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	} else {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sessionCookie = "naarum_session"
+
+const sessionDuration = 30 * 24 * time.Hour
+
+// Google Reader clients log in once and keep their token
+const greaderSessionDuration = 365 * 24 * time.Hour
+
+const minPasswordLength = 8
+
+var errBadLogin = errors.New("wrong username or password")
+
+type userContextKey struct{}
+
+// Gets the logged in user, requireLogin makes sure there is one
+func currentUser(r *http.Request) User {
+	user, _ := r.Context().Value(userContextKey{}).(User)
+	return user
+}
+
+func withUser(r *http.Request, user User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
+
+func createUser(name string, password string, admin bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, ": \t\n") {
+		return fmt.Errorf("%w: usernames can't be empty or contain spaces or colons", errInvalid)
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("%w: passwords need at least %d characters", errInvalid, minPasswordLength)
+	}
+	_, _, err := userByNameDb(name)
+	if err == nil {
+		return fmt.Errorf("%w: user %s already exists", errInvalid, name)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if admin {
+		// the first admin gets the articles, feeds and tags from before there were accounts
+		claimed, err := claimDefaultUserDb(name, string(hash), feverKey(name, password))
+		if err != nil || claimed {
+			return err
+		}
+	}
+	return createUserDb(name, string(hash), feverKey(name, password), admin)
+}
+
+// The api_key Fever clients send is the MD5 of "name:password", so it has to be stored when the password is set
+func feverKey(name string, password string) string {
+	sum := md5.Sum([]byte(name + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// Compared against when the user doesn't exist, so a login takes as long either way
+var unknownUserHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func authenticate(name string, password string) (User, error) {
+	user, hash, err := userByNameDb(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+		return User{}, errBadLogin
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return User{}, errBadLogin
+	}
+	return user, nil
+}
+
+func newToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// Starts a session, returning the token the client sends back. Only the hash of the token is stored.
+func startSession(user User, kind string, duration time.Duration) (string, error) {
+	token := newToken()
+	err := createSessionDb(hashToken(token), user.Id, kind, duration)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Gets the user from a token, returns errBadLogin if it isn't a session or has expired
+func sessionUser(token string, kind string) (User, error) {
+	if token == "" {
+		return User{}, errBadLogin
+	}
+	user, err := sessionUserDb(hashToken(token), kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errBadLogin
+	}
+	return user, err
+}
+
+// Paths that are reachable without logging in. The Google Reader and Fever APIs check their own credentials.
+func publicPath(path string) bool {
+	if path == "/login" || path == "/accounts/ClientLogin" || path == "/fever/" || strings.HasPrefix(path, "/reader/api/") {
+		return true
+	}
+	// the stylesheets and scripts the login page needs
+	_, err := static.ReadFile("static" + path)
+	return path != "/" && err == nil
+}
+
+// Responds with an error in the JSON API's format for its routes, or as text otherwise
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		writeJson(w, status, apiError{apiErrorBody{code, message}})
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// Makes sure every other request comes from a logged in user, and adds the user to the request context.
+// Requests made with the session cookie are also checked for CSRF, see csrf.go.
+func requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) {
+			// other sites shouldn't be able to log the browser in to an account of theirs
+			if r.URL.Path == "/login" && !checkCsrf(w, r, "") {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			serveWithApiToken(next, w, r, bearer)
+			return
+		}
+
+		var token string
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			token = cookie.Value
+		}
+		user, err := sessionUser(token, "browser")
+		if err == nil {
+			if !checkCsrf(w, r, token) {
+				return
+			}
+			setCsrfCookie(w, r, token)
+			next.ServeHTTP(w, withUser(r, user))
+			return
+		}
+		if !errors.Is(err, errBadLogin) {
+			slog.ErrorContext(r.Context(), "failed to check session", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		loginUrl := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/v1/"):
+			writeJson(w, http.StatusUnauthorized, apiError{apiErrorBody{"unauthorized", "log in to use the API"}})
+		case r.Header.Get("HX-Request") != "":
+			// htmx follows this header instead of swapping in the response
+			w.Header().Set("HX-Redirect", "/login")
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodGet:
+			http.Redirect(w, r, loginUrl, http.StatusSeeOther)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("log in first"))
+		}
+	})
+}
+
+// Only redirects to paths on this server after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/unread"
+	}
+	return next
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		// the cookie can only be secure when served over HTTPS, which is usually done by a reverse proxy
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	page := Login{Next: safeNext(r.URL.Query().Get("next"))}
+
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		parsed, err := url.ParseQuery(string(body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		page.Next = safeNext(parsed.Get("next"))
+
+		user, err := authenticate(parsed.Get("username"), parsed.Get("password"))
+		if err == nil {
+			token, err := startSession(user, "browser", sessionDuration)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to start session", "user", user.Name, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			slog.InfoContext(r.Context(), "logged in", "user", user.Name)
+			setSessionCookie(w, r, token, int(sessionDuration.Seconds()))
+			http.Redirect(w, r, page.Next, http.StatusSeeOther)
+			return
+		}
+		if !errors.Is(err, errBadLogin) {
+			slog.ErrorContext(r.Context(), "failed to log in", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		slog.InfoContext(r.Context(), "failed login", "user", parsed.Get("username"))
+		page.Error = errBadLogin.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	err := loginTemplate.Execute(w, page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		err = deleteSessionDb(hashToken(cookie.Value))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete session", "err", err)
+		}
+	}
+	setSessionCookie(w, r, "", -1)
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: "/", MaxAge: -1})
+	// the logout button is an htmx request so it sends the CSRF token
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Set("HX-Redirect", "/login")
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// Runs a command given on the command line instead of starting the server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "create-admin":
+		if len(args) != 2 {
+			return errors.New("usage: rss-reader create-admin <username>, with the password on standard input")
+		}
+		// the password is read from standard input so it doesn't end up in the shell history
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		password = strings.TrimRight(password, "\r\n")
+		fmt.Fprintln(os.Stderr)
+		err = createUser(args[1], password, true)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "created admin %s\n", args[1])
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, the only command is create-admin", args[0])
+}
+
+func warnIfNoUsers() {
+	count, err := userCountDb()
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		slog.Warn("there are no accounts, so nobody can log in. Create one with: rss-reader create-admin <username>")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcboeker/go-duckdb/v2"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Firefox's JSON backup format
+type Bookmark struct {
+	URI   string `json:"uri"`
+	Title string `json:"title"`
+}
+type BookmarkFolder struct {
+	Children []Bookmark `json:"children"`
+}
+type Bookmarks struct {
+	Guid     string           `json:"guid"`
+	Children []BookmarkFolder `json:"children"`
+}
+
+// Bookmark files are read into memory to be parsed, so they are held to the same size as the form is read with
+const maxBookmarksSize = 4 * 1024 * 1024
+
+// A bookmark read from an imported file
+type importedBookmark struct {
+	url   string
+	title string
+	// The zero time if the file doesn't say when it was added
+	added time.Time
+	tags  []string
+	note  string
+}
+
+// Imports bookmarks from Firefox's JSON backups or the Netscape bookmarks.html format that browsers and bookmarking
+// services export, see parseNetscapeBookmarks
+func importBookmarks(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "request lacks Content-Type header", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	// 4MiB should be enough TODO: make this configurable
+	form, err := mr.ReadForm(maxBookmarksSize)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error parsing form", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer form.RemoveAll()
+	fileHeaders := form.File["file"]
+	if len(fileHeaders) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no file uploaded"))
+		return
+	}
+	file, err := fileHeaders[0].Open()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error opening form file", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer file.Close()
+	contents, err := readLimited(file, maxBookmarksSize)
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "the bookmarks file is larger than %d bytes", maxBookmarksSize)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "error reading form file", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var bookmarks []importedBookmark
+	if bytes.HasPrefix(bytes.TrimSpace(contents), []byte("{")) {
+		bookmarks, err = parseFirefoxBookmarks(contents)
+	} else {
+		bookmarks, err = parseNetscapeBookmarks(bytes.NewReader(contents))
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error parsing bookmarks", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	slog.Debug("parsed bookmarks", "bookmarks", len(bookmarks))
+
+	imported := addImportedBookmarks(currentUser(r).Id, bookmarks)
+	fmt.Fprintf(w, "Imported %d new bookmarks out of %d", imported, len(bookmarks))
+}
+
+// Reads the bookmarks in the folders of a Firefox JSON backup, which are only one level deep
+func parseFirefoxBookmarks(contents []byte) ([]importedBookmark, error) {
+	var backup Bookmarks
+	err := json.Unmarshal(contents, &backup)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks []importedBookmark
+	for _, folder := range backup.Children {
+		for _, bookmark := range folder.Children {
+			bookmarks = append(bookmarks, importedBookmark{url: bookmark.URI, title: bookmark.Title})
+		}
+	}
+	return bookmarks, nil
+}
+
+// Reads a Netscape bookmarks.html file. Bookmarks are <A> tags with their title as the text, and the folders they
+// are in are <H3> tags each followed by a <DL> of their contents. The names of the folders a bookmark is in become
+// its tags along with the ones in its TAGS attribute, and the text of the <DD> after it becomes its note.
+func parseNetscapeBookmarks(file io.Reader) ([]importedBookmark, error) {
+	decoded, err := charset.NewReader(file, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	tokenizer := html.NewTokenizer(decoded)
+
+	var bookmarks []importedBookmark
+	// the tags of the folders the current bookmark is in, empty for folders that don't become tags
+	var folders []string
+	// the folder the next <DL> is the contents of
+	var folder string
+	// one of "title", "folder" or "note" when in the text of one
+	var reading string
+	var text strings.Builder
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				if reading == "note" {
+					bookmarks[len(bookmarks)-1].note = strings.TrimSpace(text.String())
+				}
+				return bookmarks, nil
+			}
+			return nil, tokenizer.Err()
+		}
+		if tokenType == html.TextToken {
+			if reading != "" {
+				text.Write(tokenizer.Text())
+			}
+			continue
+		}
+
+		token := tokenizer.Token()
+		// <DD> is never closed, so the note ends at whatever comes next
+		if reading == "note" {
+			bookmarks[len(bookmarks)-1].note = strings.TrimSpace(text.String())
+			reading = ""
+		}
+		if tokenType == html.EndTagToken {
+			switch {
+			case token.Data == "a" && reading == "title":
+				bookmarks[len(bookmarks)-1].title = strings.TrimSpace(text.String())
+				reading = ""
+			case token.Data == "h3" && reading == "folder":
+				folder = bookmarkTagName(text.String())
+				reading = ""
+			case token.Data == "dl" && len(folders) > 0:
+				folders = folders[:len(folders)-1]
+			}
+			continue
+		}
+		if tokenType != html.StartTagToken {
+			continue
+		}
+
+		switch token.Data {
+		case "h3":
+			reading = "folder"
+			text.Reset()
+			// the toolbar and the unsorted bookmarks are where bookmarks are kept, not what they are about
+			if bookmarkAttr(token, "personal_toolbar_folder") != "" || bookmarkAttr(token, "unfiled_bookmarks_folder") != "" {
+				reading = ""
+				folder = ""
+			}
+		case "dl":
+			folders = append(folders, folder)
+			folder = ""
+		case "a":
+			bookmark := importedBookmark{url: bookmarkAttr(token, "href"), added: bookmarkDate(bookmarkAttr(token, "add_date"))}
+			for _, tag := range folders {
+				if tag != "" {
+					bookmark.tags = append(bookmark.tags, tag)
+				}
+			}
+			for _, tag := range strings.Split(bookmarkAttr(token, "tags"), ",") {
+				if tag = bookmarkTagName(tag); tag != "" {
+					bookmark.tags = append(bookmark.tags, tag)
+				}
+			}
+			bookmarks = append(bookmarks, bookmark)
+			reading = "title"
+			text.Reset()
+		case "dd":
+			if len(bookmarks) > 0 {
+				reading = "note"
+				text.Reset()
+			}
+		}
+	}
+}
+
+func bookmarkAttr(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Turns a folder name or tag from another app into a tag name, which can't have spaces or start with # or -
+func bookmarkTagName(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), "-"))
+	return strings.TrimLeft(name, "#-")
+}
+
+// ADD_DATE is in seconds since the epoch, though some apps use milliseconds or microseconds instead
+func bookmarkDate(value string) time.Time {
+	date, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || date <= 0 {
+		return time.Time{}
+	}
+	switch {
+	case date > 1e14:
+		return time.UnixMicro(date)
+	case date > 1e11:
+		return time.UnixMilli(date)
+	}
+	return time.Unix(date, 0)
+}
+
+// Adds imported bookmarks to a user's bookmarks without fetching them, returns how many were new to the user.
+// Bookmarks that aren't web pages, like javascript: links, are skipped.
+func addImportedBookmarks(userId int64, bookmarks []importedBookmark) int {
+	imported := 0
+	for _, bookmark := range bookmarks {
+		if !strings.HasPrefix(bookmark.url, "http://") && !strings.HasPrefix(bookmark.url, "https://") {
+			continue
+		}
+		page, err := normalizeUrl(bookmark.url)
+		if err != nil {
+			slog.Debug("skipping bookmark", "url", bookmark.url, "err", err)
+			continue
+		}
+		date := bookmark.added
+		if date.IsZero() {
+			date = time.Now()
+		}
+		article := Article{
+			Url:        page,
+			EscapedUrl: "",
+			Title:      firstNonEmpty(bookmark.title, page),
+			Date:       date.Format(time.RFC3339),
+			Comments:   []Comments{},
+			Tags:       []string{"bookmark"},
+		}
+		_, err = addArticleDb(article, "")
+		if err != nil {
+			slog.Error("failed to add bookmark", "url", page, "err", err)
+			continue
+		}
+		// the article may already be there for someone else
+		added, err := addUserArticleDb(userId, page)
+		if err != nil {
+			slog.Error("failed to add bookmark", "url", page, "err", err)
+			continue
+		}
+		for _, tag := range bookmark.tags {
+			if validTagName(tag) == nil {
+				addTagDb(userId, page, tag)
+			}
+		}
+		if bookmark.note != "" {
+			err = setNoteDb(userId, page, bookmark.note)
+			if err != nil {
+				slog.Error("failed to add bookmark note", "url", page, "err", err)
+			}
+		}
+		if added || len(bookmark.tags) > 0 {
+			apply_archive_policy(db, page)
+		}
+		if added {
+			imported++
+		}
+	}
+	return imported
+}
+
+// Exports the user's bookmarks and tagged articles in the Netscape bookmarks.html format, which browsers can import.
+// Tags go in the TAGS attribute rather than folders since an article can have several.
+func exportBookmarks(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT url, title, pubdate, tags, coalesce(note, '') FROM "+userArticles+" WHERE feed IS NULL OR len(tags) > 0 ORDER BY pubdate", currentUser(r).Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "unable to get bookmarks", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.html"`)
+	io.WriteString(w, "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n"+
+		"<!-- This is an automatically generated file.\n     It will be read and overwritten.\n     DO NOT EDIT! -->\n"+
+		"<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n"+
+		"<TITLE>Bookmarks</TITLE>\n<H1>Bookmarks</H1>\n<DL><p>\n")
+	for rows.Next() {
+		var url, title, pubdate, note string
+		var tags duckdb.Composite[[]string]
+		err = rows.Scan(&url, &title, &pubdate, &tags, &note)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to scan bookmark", "err", err)
+			return
+		}
+
+		attrs := fmt.Sprintf(` HREF="%s"`, html.EscapeString(url))
+		if added, err := time.Parse(time.RFC3339, pubdate); err == nil {
+			attrs += fmt.Sprintf(` ADD_DATE="%d"`, added.Unix())
+		}
+		if len(tags.Get()) > 0 {
+			attrs += fmt.Sprintf(` TAGS="%s"`, html.EscapeString(strings.Join(tags.Get(), ",")))
+		}
+		fmt.Fprintf(w, "    <DT><A%s>%s</A>\n", attrs, html.EscapeString(title))
+		if note != "" {
+			fmt.Fprintf(w, "    <DD>%s\n", html.EscapeString(note))
+		}
+	}
+	io.WriteString(w, "</DL><p>\n")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+)
+
+// Requests that change something and are authenticated with the session cookie have to come from this server's pages,
+// which send the session's CSRF token in a header through hx-headers (see header.html). Other sites can't read the
+// token, so a form or fetch from them is rejected even though the browser sends the session cookie along.
+
+// Holds the CSRF token for the page's scripts, so unlike the session cookie it isn't HttpOnly
+const csrfCookie = "naarum_csrf"
+
+const csrfHeader = "X-CSRF-Token"
+
+// The CSRF token of a browser session. It is derived from the session token so it doesn't need to be stored,
+// and can't be worked out without the session cookie.
+func csrfToken(session string) string {
+	return hashToken("csrf:" + session)
+}
+
+// Whether a request can change something, and so needs to be protected
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// Whether a request comes from a page on this server, going by its Origin or its Referer if there isn't one.
+// Browsers send an Origin with every cross-site request that isn't a GET, so requests with neither aren't from one.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	parsed, err := url.Parse(source)
+	return err == nil && parsed.Host != "" && parsed.Host == r.Host
+}
+
+// Rejects unsafe requests from other sites. session is the session token the request was authenticated with, the
+// request has to have its CSRF token too unless it is empty, like when logging in.
+func checkCsrf(w http.ResponseWriter, r *http.Request, session string) bool {
+	if !unsafeMethod(r.Method) {
+		return true
+	}
+	if !sameOrigin(r) {
+		writeAuthError(w, r, http.StatusForbidden, "forbidden", "cross-site request rejected")
+		return false
+	}
+	if session == "" {
+		return true
+	}
+	token := r.Header.Get(csrfHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken(session))) != 1 {
+		writeAuthError(w, r, http.StatusForbidden, "forbidden", "missing or invalid CSRF token, reload the page")
+		return false
+	}
+	return true
+}
+
+// Gives the page the CSRF token of its session, unless it already has it
+func setCsrfCookie(w http.ResponseWriter, r *http.Request, session string) {
+	token := csrfToken(session)
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value == token {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionDuration.Seconds()),
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCsrf(t *testing.T) {
+	useTestDb(t)
+	user, session := testUser(t, "alice")
+	apiToken, err := createApiToken(user, "script", []string{"read", "write"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := requireLogin(okHandler())
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		// whether to send the session cookie
+		session bool
+		status  int
+	}{
+		{
+			name:    "same origin with token",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Origin": "http://example.com", csrfHeader: csrfToken(session)},
+			session: true,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "foreign origin",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Origin": "https://evil.example", csrfHeader: csrfToken(session)},
+			session: true,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "foreign referer without origin",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Referer": "https://evil.example/page", csrfHeader: csrfToken(session)},
+			session: true,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "missing token",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Origin": "http://example.com"},
+			session: true,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "wrong token",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Origin": "http://example.com", csrfHeader: csrfToken("another session")},
+			session: true,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "login from another site",
+			method:  http.MethodPost,
+			path:    "/login",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "login from this site",
+			method:  http.MethodPost,
+			path:    "/login",
+			headers: map[string]string{"Origin": "http://example.com"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "get from another site",
+			method:  http.MethodGet,
+			path:    "/unread",
+			headers: map[string]string{"Referer": "https://evil.example/page"},
+			session: true,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "bearer token",
+			method:  http.MethodPost,
+			path:    "/api/tags/create",
+			headers: map[string]string{"Origin": "https://evil.example", "Authorization": "Bearer " + apiToken},
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader("name=test"))
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if test.session {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCsrfCookie(t *testing.T) {
+	useTestDb(t)
+	_, session := testUser(t, "alice")
+
+	r := httptest.NewRequest(http.MethodGet, "/unread", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	w := httptest.NewRecorder()
+	requireLogin(okHandler()).ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookie {
+			if cookie.Value != csrfToken(session) {
+				t.Errorf("CSRF cookie = %q, want the session's token", cookie.Value)
+			}
+			if cookie.HttpOnly {
+				t.Error("the CSRF cookie has to be readable by the page's scripts")
+			}
+			if cookie.SameSite != http.SameSiteStrictMode {
+				t.Errorf("CSRF cookie SameSite = %v, want strict", cookie.SameSite)
+			}
+			return
+		}
+	}
+	t.Error("pages didn't get a CSRF cookie")
+}
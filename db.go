@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -29,24 +30,119 @@ func runSQL(db *sql.DB, filename string) error {
 	return nil
 }
 
-func initDb() (*sql.DB, error) {
-	db, err := sql.Open("duckdb", "data/data.db")
+// Runs a migration unless it has already been applied to this database
+func runMigration(db *sql.DB, filename string) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS migrations(name STRING NOT NULL PRIMARY KEY)")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var applied bool
+	err = db.QueryRow("SELECT count(*) > 0 FROM migrations WHERE name=?", filename).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return nil
 	}
 
-	err = runSQL(db, "migrations/0.sql")
+	err = runSQL(db, filename)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to run %s: %v", filename, err)
 	}
-	err = runSQL(db, "migrations/1.sql")
+	_, err = db.Exec("INSERT INTO migrations VALUES (?)", filename)
+	return err
+}
+
+func initDb() (*sql.DB, error) {
+	return openDb("data/data.db")
+}
+
+// Opens the database at path and brings it up to date, creating it if it doesn't exist
+func openDb(path string) (*sql.DB, error) {
+	db, err := sql.Open("duckdb", path)
 	if err != nil {
 		return nil, err
 	}
 
+	// migrations are only run once, so new changes need to go in a new file
+	migrationFiles := []string{
+		"migrations/0.sql",
+		"migrations/1.sql",
+		"migrations/2.sql",
+		"migrations/3.sql",
+		"migrations/4.sql",
+		"migrations/5.sql",
+		"migrations/6.sql",
+		"migrations/7.sql",
+		"migrations/8.sql",
+		"migrations/9.sql",
+		"migrations/10.sql",
+		"migrations/11.sql",
+		"migrations/12.sql",
+		"migrations/13.sql",
+		"migrations/14.sql",
+		"migrations/15.sql",
+		"migrations/16.sql",
+		"migrations/17.sql",
+		"migrations/18.sql",
+		"migrations/19.sql",
+		"migrations/20.sql",
+		"migrations/21.sql",
+		"migrations/22.sql",
+		"migrations/23.sql",
+		"migrations/24.sql",
+	}
+	for _, filename := range migrationFiles {
+		err = runMigration(db, filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
+// Articles and feeds are shared between users, so queries for what a user sees select from this instead of the
+// articles table. It adds the user's read_at, tags and note and the title they gave the article, and takes the user
+// id as the first argument.
+const userArticles = "(SELECT articles.* EXCLUDE (title), coalesce(user_articles.title, articles.title) AS title, user_articles.read_at, user_articles.tags, user_articles.note FROM articles JOIN user_articles ON article=url WHERE user_id=?) AS articles"
+
+// The tags every user gave an article, which is what archive rules match on
+const everyonesTags = "coalesce((SELECT list_distinct(flatten(list(tags))) FROM user_articles WHERE article=url), [])"
+
+// Subscribes a user to a feed, giving them the articles it already has
+func subscribeDb(userId int64, feed string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO subscriptions(user_id, feed) VALUES (?, ?)", userId, feed)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to feed: %v", err)
+	}
+	_, err = tx.Exec("INSERT OR IGNORE INTO user_articles(user_id, article, read_at, tags) SELECT ?, url, NULL, [] FROM articles WHERE feed=? "+
+		"AND url NOT IN (SELECT article FROM deleted_articles WHERE user_id=?)", userId, feed, userId)
+	if err != nil {
+		return fmt.Errorf("failed to add feed articles: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Unsubscribes a user from a feed, the feed itself is removed once nobody is subscribed to it
+func unsubscribeDb(userId int64, feed string) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE user_id=? AND feed=?", userId, feed)
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe from feed: %v", err)
+	}
+	subscribed, err := existsDb("SELECT count(*) > 0 FROM subscriptions WHERE feed=?", feed)
+	if err != nil || subscribed {
+		return err
+	}
+	return removeFeedDb(feed)
+}
+
 func removeFeedDb(url string) error {
 	_, err := db.Exec("DELETE FROM comments WHERE feed=?", url)
 	if err != nil {
@@ -66,8 +162,8 @@ func removeFeedDb(url string) error {
 	return nil
 }
 
-func markReadDb(url string) {
-	res, err := db.Exec("UPDATE articles SET read=true WHERE url=?", url)
+func markReadDb(userId int64, url string) {
+	res, err := db.Exec("UPDATE user_articles SET read_at=coalesce(read_at, current_localtimestamp()) WHERE user_id=? AND article=?", userId, url)
 	if err != nil {
 		panic(err)
 	}
@@ -80,8 +176,8 @@ func markReadDb(url string) {
 	}
 }
 
-func unreadArticlesDb(limit int) []Article {
-	articleRows, err := db.Query("SELECT url, title, pubdate FROM articles WHERE read=false ORDER BY pubdate DESC LIMIT ?", limit)
+func unreadArticlesDb(userId int64, limit int) []Article {
+	articleRows, err := db.Query("SELECT url, title, pubdate FROM "+userArticles+" WHERE read_at IS NULL ORDER BY pubdate DESC LIMIT ?", userId, limit)
 	if err != nil {
 		panic(err)
 	}
@@ -115,46 +211,78 @@ func unreadArticlesDb(limit int) []Article {
 	return articleList
 }
 
-func readArticlesDb(limit int) []Article {
-	articleRows, err := db.Query("SELECT url, title,pubdate FROM articles WHERE read=true ORDER BY pubdate DESC LIMIT ?", limit)
+// Adds an article from a feed, or a bookmark if feed is empty. Returns false if the article already existed.
+// Articles from a feed are given to everyone subscribed to it who hasn't deleted it, bookmarks need addUserArticleDb.
+func addArticleDb(article Article, feed string) (bool, error) {
+	var feedValue any
+	if feed != "" {
+		feedValue = feed
+		// the feed still lists articles after they're deleted, they aren't added back if every subscriber deleted them
+		deleted, err := existsDb("SELECT count(*) > 0 FROM deleted_articles WHERE article=? AND NOT EXISTS "+
+			"(SELECT * FROM subscriptions WHERE feed=? AND user_id NOT IN (SELECT user_id FROM deleted_articles WHERE article=?))", article.Url, feed, article.Url)
+		if err != nil || deleted {
+			return false, err
+		}
+	}
+	res, err := db.Exec("INSERT OR IGNORE INTO articles(url, title, pubdate, archive, dead_link, fetch_failures, feed, item_id) VALUES (?, ?, ?, NULL, FALSE, 0, ?, nextval('article_item_id'))",
+		article.Url, article.Title, article.Date, feedValue)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-
-	var articleList []Article
-	for articleRows.Next() {
-		var article Article
-		_ = articleRows.Scan(&article.Url, &article.Title, &article.Date)
-		article.EscapedUrl = url.QueryEscape(article.Url)
-
-		date, err := time.Parse(time.RFC3339, article.Date)
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if feed == "" {
+		return added > 0, nil
+	}
+	// articles from before they remembered their feed, or that were bookmarks first, are new to the feed too
+	newToFeed := added
+	if added == 0 {
+		res, err = db.Exec("UPDATE articles SET feed=? WHERE url=? AND feed IS NULL", feed, article.Url)
 		if err != nil {
-			panic(err)
+			return false, err
 		}
-		article.Date = date.Format(time.RFC1123)
-
-		commentRows, err := db.Query("SELECT title, comments FROM comments JOIN feeds ON feed=url WHERE article=?", article.Url)
+		newToFeed, err = res.RowsAffected()
 		if err != nil {
-			panic(err)
+			return false, err
 		}
-		var commentsArray []Comments
-		for commentRows.Next() {
-			var comment Comments
-			_ = commentRows.Scan(&comment.Feed, &comment.Url)
-			commentsArray = append(commentsArray, comment)
-		}
-		article.Comments = commentsArray
-
-		articleList = append(articleList, article)
 	}
-	return articleList
+	// subscribers who removed an article the feed still lists don't get it back, subscribeDb gives new ones what is there
+	if newToFeed > 0 {
+		_, err = db.Exec("INSERT OR IGNORE INTO user_articles(user_id, article, read_at, tags) SELECT user_id, ?, NULL, [] FROM subscriptions WHERE feed=? "+
+			"AND user_id NOT IN (SELECT user_id FROM deleted_articles WHERE article=?)", article.Url, feed, article.Url)
+	}
+	return added > 0, err
 }
 
-func addArticleDb(article Article) error {
-	_, err := db.Exec("INSERT OR IGNORE INTO articles VALUES (?, ?, ?, [], FALSE, NULL, FALSE)", article.Url, article.Title, article.Date)
+// Fills in the metadata of an article that it doesn't have yet, keeping what is already known like from an archive
+func setArticleMetadataDb(url string, metadata Extracted) error {
+	var published any
+	if !metadata.Published.IsZero() {
+		published = metadata.Published
+	}
+	_, err := db.Exec("UPDATE articles SET description=coalesce(description, nullif(?, '')), byline=coalesce(byline, nullif(?, '')), "+
+		"published=coalesce(published, ?), image=coalesce(image, nullif(?, '')) WHERE url=?",
+		metadata.Description, metadata.Byline, published, metadata.Image, url)
 	return err
 }
 
+// Gives a user an article that has already been added. Returns false if they already had it.
+// Saving an article they deleted from a feed brings it back.
+func addUserArticleDb(userId int64, article_url string) (bool, error) {
+	_, err := db.Exec("DELETE FROM deleted_articles WHERE user_id=? AND article=?", userId, article_url)
+	if err != nil {
+		return false, err
+	}
+	res, err := db.Exec("INSERT OR IGNORE INTO user_articles(user_id, article, read_at, tags) VALUES (?, ?, NULL, [])", userId, article_url)
+	if err != nil {
+		return false, err
+	}
+	added, err := res.RowsAffected()
+	return added > 0, err
+}
+
 func conditionFromQuery(query string) (string, error) {
 	r := regexp.MustCompile(`^#?([a-z]|[A-Z]|[0-9])+$`)
 
@@ -167,20 +295,21 @@ func conditionFromQuery(query string) (string, error) {
 		if strings.HasPrefix(word, "#") {
 			condition = condition + "list_contains(tags, '" + word[1:] + "') AND "
 		} else {
-			condition = condition + "title ILIKE '%" + word + "%' AND "
+			// archives include the text extracted from PDFs, which often have useless titles
+			condition = condition + "(title ILIKE '%" + word + "%' OR archive ILIKE '%" + word + "%') AND "
 		}
 	}
 	condition = condition + "true"
 	return condition, nil
 }
 
-func queryArticlesDb(query string) []Article {
+func queryArticlesDb(userId int64, query string) []Article {
 	condition, err := conditionFromQuery(query)
 	if err != nil {
 		panic(err)
 	}
 
-	articleRows, err := db.Query("SELECT url, title, pubdate, tags FROM articles WHERE " + condition)
+	articleRows, err := db.Query("SELECT url, title, pubdate, tags, read_at IS NOT NULL FROM "+userArticles+" WHERE "+condition, userId)
 	if err != nil {
 		panic(err)
 	}
@@ -189,7 +318,7 @@ func queryArticlesDb(query string) []Article {
 	for articleRows.Next() {
 		var article Article
 		var tagsArr duckdb.Composite[[]string]
-		_ = articleRows.Scan(&article.Url, &article.Title, &article.Date, &tagsArr)
+		_ = articleRows.Scan(&article.Url, &article.Title, &article.Date, &tagsArr, &article.Read)
 		article.Tags = tagsArr.Get()
 		article.EscapedUrl = url.QueryEscape(article.Url)
 
@@ -218,8 +347,9 @@ func queryArticlesDb(query string) []Article {
 	return articleList
 }
 
-func feedsDb() []feed {
-	feed_rows, err := db.Query("SELECT url, title, description FROM feeds")
+// Gets the feeds a user is subscribed to
+func feedsDb(userId int64) []feed {
+	feed_rows, err := db.Query("SELECT url, title, description, coalesce(folder, '') FROM feeds JOIN subscriptions ON feed=url WHERE user_id=?", userId)
 	if err != nil {
 		panic(err)
 	}
@@ -227,7 +357,7 @@ func feedsDb() []feed {
 	var feeds []feed
 	for feed_rows.Next() {
 		var feed feed
-		_ = feed_rows.Scan(&feed.FeedUrl, &feed.Title, &feed.Description)
+		_ = feed_rows.Scan(&feed.FeedUrl, &feed.Title, &feed.Description, &feed.Folder)
 		url, err := url.Parse(feed.FeedUrl)
 		if err != nil {
 			panic(err)
@@ -259,37 +389,70 @@ func getFeedDb(requested_url string) feed {
 	return feed
 }
 
-func addTagDb(url string, tag string) {
-	_, err := db.Query("UPDATE articles SET tags=list_distinct(list_append(tags, ?)) WHERE url=?", tag, url)
+// Gives an article a title only the user sees, unless it is empty or the same as the article's own title
+func setUserTitleDb(userId int64, url string, title string) error {
+	_, err := db.Exec("UPDATE user_articles SET title=? WHERE user_id=? AND article=? AND ? != '' AND ? != (SELECT title FROM articles WHERE url=?)",
+		title, userId, url, title, title, url)
+	return err
+}
+
+func setNoteDb(userId int64, url string, note string) error {
+	_, err := db.Exec("UPDATE user_articles SET note=? WHERE user_id=? AND article=?", note, userId, url)
+	return err
+}
+
+func addTagDb(userId int64, url string, tag string) {
+	_, err := db.Exec("UPDATE user_articles SET tags=list_distinct(list_append(tags, ?)) WHERE user_id=? AND article=?", tag, userId, url)
 	if err != nil {
 		panic(err)
 	}
+	err = touchTag(db, userId, tag)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Creates the tag if it doesn't exist and updates when it was last used
+func touchTag(db execer, userId int64, tag string) error {
+	_, err := db.Exec("INSERT INTO tags VALUES (?, ?, false, NULL, current_localtimestamp()) ON CONFLICT DO UPDATE SET last_used=EXCLUDED.last_used", userId, tag)
+	return err
 }
 
-func removeTagDb(url string, tag string) {
-	_, err := db.Query("UPDATE articles SET tags=list_filter(tags, lambda x: x != ?) WHERE url=?", tag, url)
+func removeTagDb(userId int64, url string, tag string) {
+	_, err := db.Exec("UPDATE user_articles SET tags=list_filter(tags, lambda x: x != ?) WHERE user_id=? AND article=?", tag, userId, url)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// Puts a feed the user is subscribed to in a folder, or takes it out of its folder if folder is empty
+func setFolderDb(userId int64, feed string, folder string) error {
+	_, err := db.Exec("UPDATE subscriptions SET folder=nullif(?, '') WHERE user_id=? AND feed=?", folder, userId, feed)
+	return err
+}
+
+// Adds a feed unless someone is already subscribed to it, see subscribeDb
 func addFeedDb(url string) error {
-	_, err := db.Query("INSERT INTO feeds VALUES(?, '', '', NULL, [])", url)
+	_, err := db.Exec("INSERT OR IGNORE INTO feeds(url, title, description, last_updated, tags, feed_id) VALUES(?, '', '', NULL, [], nextval('feed_id'))", url)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func getArticleDb(article_url string) Article {
+func getArticleDb(userId int64, article_url string) Article {
 	var article Article
 	// I don't think there is any reason for the feed names and comment links to match up to each other
 	// TODO: fix that
-	row := db.QueryRow("SELECT list_filter(list(comments), lambda x: x != NULL), list_filter(list(feeds.title), lambda x: x != NULL), ANY_VALUE(articles.url), ANY_VALUE(articles.title), ANY_VALUE(pubdate), ANY_VALUE(articles.tags) FROM articles LEFT JOIN comments ON articles.url=comments.article LEFT JOIN feeds ON comments.feed=feeds.url WHERE articles.url=? GROUP BY articles.url;", article_url)
+	row := db.QueryRow("SELECT list_filter(list(comments), lambda x: x != NULL), list_filter(list(feeds.title), lambda x: x != NULL), ANY_VALUE(articles.url), ANY_VALUE(articles.title), ANY_VALUE(pubdate), ANY_VALUE(articles.tags), ANY_VALUE(read_at) IS NOT NULL, coalesce(ANY_VALUE(note), ''), coalesce(ANY_VALUE(articles.description), '') FROM "+userArticles+" LEFT JOIN comments ON articles.url=comments.article LEFT JOIN feeds ON comments.feed=feeds.url WHERE articles.url=? GROUP BY articles.url;", userId, article_url)
 	var tagsArr duckdb.Composite[[]string]
 	var commentsArr duckdb.Composite[[]string]
 	var feedCommentsArr duckdb.Composite[[]string]
-	err := row.Scan(&commentsArr, &feedCommentsArr, &article.Url, &article.Title, &article.Date, &tagsArr)
+	err := row.Scan(&commentsArr, &feedCommentsArr, &article.Url, &article.Title, &article.Date, &tagsArr, &article.Read, &article.Note, &article.Description)
 	if err != nil {
 		panic(err)
 	}
@@ -316,3 +479,1205 @@ func getArticleDb(article_url string) Article {
 
 	return article
 }
+
+// Records that an article was dismissed from the unread page so that it can be undone later.
+// tag should be empty if the article was only marked read.
+func recordDismissalDb(userId int64, session string, article_url string, tag string) error {
+	var tagValue any
+	if tag != "" {
+		// don't remember tags that were already on the article, undoing shouldn't remove them
+		var present bool
+		err := db.QueryRow("SELECT list_contains(tags, ?) FROM user_articles WHERE user_id=? AND article=?", tag, userId, article_url).Scan(&present)
+		if err != nil {
+			return fmt.Errorf("failed to check article tags: %v", err)
+		}
+		if !present {
+			tagValue = tag
+		}
+	}
+	_, err := db.Exec("INSERT INTO dismissals(session, article, tag, dismissed, user_id) VALUES (?, ?, ?, current_localtimestamp(), ?)", session, article_url, tagValue, userId)
+	if err != nil {
+		return fmt.Errorf("failed to record dismissal: %v", err)
+	}
+	return nil
+}
+
+// Reverts a single dismissal, marking the article unread and removing the tag it added
+func restoreDismissalDb(userId int64, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	article, err := restoreDismissalTx(tx, userId, id)
+	if err != nil {
+		return err
+	}
+	err = forgetDismissalsTx(tx, userId, article)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reverts a dismissal as part of a transaction, returns the dismissed article
+func restoreDismissalTx(tx *sql.Tx, userId int64, id int64) (string, error) {
+	var article string
+	var tag sql.NullString
+	err := tx.QueryRow("SELECT article, tag FROM dismissals WHERE id=? AND user_id=?", id, userId).Scan(&article, &tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to get dismissal %d: %v", id, err)
+	}
+	if tag.Valid {
+		_, err = tx.Exec("UPDATE user_articles SET tags=list_filter(tags, lambda x: x != ?) WHERE user_id=? AND article=?", tag.String, userId, article)
+		if err != nil {
+			return "", fmt.Errorf("failed to remove tag: %v", err)
+		}
+	}
+	res, err := tx.Exec("UPDATE user_articles SET read_at=NULL WHERE user_id=? AND article=?", userId, article)
+	if err != nil {
+		return "", fmt.Errorf("failed to mark article unread: %v", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", fmt.Errorf("attempted to mark nonexistent article unread: %s", article)
+	}
+	_, err = tx.Exec("DELETE FROM dismissals WHERE id=?", id)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete dismissal: %v", err)
+	}
+	return article, nil
+}
+
+// A dismissal can't be undone once the article is unread again, so the rest of the article's dismissals are removed
+func forgetDismissalsTx(tx *sql.Tx, userId int64, article string) error {
+	_, err := tx.Exec("DELETE FROM dismissals WHERE user_id=? AND article=?", userId, article)
+	if err != nil {
+		return fmt.Errorf("failed to delete dismissals: %v", err)
+	}
+	return nil
+}
+
+// Reverts the last count dismissals made in the given session, returns how many were reverted.
+// Either all of them are reverted or none are.
+func undoDismissalsDb(userId int64, session string, count int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM dismissals WHERE user_id=? AND session=? ORDER BY id DESC LIMIT ?", userId, session, count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get dismissals: %v", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	// the newest dismissals are restored first, so the tags added by each one are removed even when the same article
+	// was dismissed several times
+	var articles []string
+	for _, id := range ids {
+		article, err := restoreDismissalTx(tx, userId, id)
+		if err != nil {
+			return 0, err
+		}
+		articles = append(articles, article)
+	}
+	for _, article := range articles {
+		err = forgetDismissalsTx(tx, userId, article)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Counts the dismissals in a session that can still be undone
+func undoableDismissalsDb(userId int64, session string) int {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM dismissals WHERE user_id=? AND session=?", userId, session).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+func markUnreadDb(userId int64, article_url string) error {
+	res, err := db.Exec("UPDATE user_articles SET read_at=NULL WHERE user_id=? AND article=?", userId, article_url)
+	if err != nil {
+		return fmt.Errorf("failed to mark article unread: %v", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("attempted to mark nonexistent article unread: %s", article_url)
+	}
+	// a dismissal can't be undone once the article is unread again
+	_, err = db.Exec("DELETE FROM dismissals WHERE user_id=? AND article=?", userId, article_url)
+	if err != nil {
+		return fmt.Errorf("failed to delete dismissals: %v", err)
+	}
+	return nil
+}
+
+// Gets a page of read articles, most recently read first
+func historyDb(userId int64, limit int, offset int) []HistoryEntry {
+	rows, err := db.Query("SELECT url, title, read_at, id, dismissals.tag FROM "+userArticles+" "+
+		"LEFT JOIN (SELECT article, max(id) AS id FROM dismissals WHERE user_id=? GROUP BY article) AS latest ON article=url "+
+		"LEFT JOIN dismissals USING (id) "+
+		"WHERE read_at IS NOT NULL ORDER BY read_at DESC LIMIT ? OFFSET ?", userId, userId, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var readAt time.Time
+		var id sql.NullInt64
+		var tag sql.NullString
+		err = rows.Scan(&entry.Article.Url, &entry.Article.Title, &readAt, &id, &tag)
+		if err != nil {
+			panic(err)
+		}
+		entry.ReadAt = readAt.Format(time.RFC1123)
+		entry.DismissalId = id.Int64
+		entry.Tag = tag.String
+		entry.Article.EscapedUrl = url.QueryEscape(entry.Article.Url)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Gets every tag, favorites first, in the order they are shown on the unread page
+func tagsDb(userId int64) []Tag {
+	rows, err := db.Query("SELECT name, favorite, count(article), count(article) FILTER (WHERE read_at IS NULL), last_used FROM tags "+
+		"LEFT JOIN (SELECT article, read_at, unnest(tags) AS tag FROM user_articles WHERE user_id=?) ON tag=name "+
+		"WHERE user_id=? GROUP BY name, favorite, position, last_used ORDER BY favorite DESC, position NULLS LAST, name", userId, userId)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		var lastUsed sql.NullTime
+		err = rows.Scan(&tag.Name, &tag.Favorite, &tag.Count, &tag.Unread, &lastUsed)
+		if err != nil {
+			panic(err)
+		}
+		if lastUsed.Valid {
+			tag.LastUsed = lastUsed.Time.Format(time.RFC1123)
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func favoriteTagsDb(userId int64) []string {
+	var favorites []string
+	for _, tag := range tagsDb(userId) {
+		if tag.Favorite {
+			favorites = append(favorites, tag.Name)
+		}
+	}
+	return favorites
+}
+
+func createTagDb(userId int64, name string, favorite bool) error {
+	_, err := db.Exec("INSERT INTO tags VALUES (?, ?, ?, NULL, NULL)", userId, name, favorite)
+	if err != nil {
+		return fmt.Errorf("failed to create tag %s: %v", name, err)
+	}
+	return nil
+}
+
+func setTagFavoriteDb(userId int64, name string, favorite bool) error {
+	res, err := db.Exec("UPDATE tags SET favorite=? WHERE user_id=? AND name=?", favorite, userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to update tag %s: %v", name, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("attempted to update nonexistent tag: %s", name)
+	}
+	return nil
+}
+
+// Replaces a tag with another one on every article of a user
+func retagArticles(tx *sql.Tx, userId int64, from string, to string) error {
+	_, err := tx.Exec("UPDATE user_articles SET tags=list_distinct(list_transform(tags, lambda x: CASE WHEN x = ? THEN ? ELSE x END)) WHERE user_id=? AND list_contains(tags, ?)", from, to, userId, from)
+	if err != nil {
+		return fmt.Errorf("failed to retag articles: %v", err)
+	}
+	_, err = tx.Exec("UPDATE dismissals SET tag=? WHERE user_id=? AND tag=?", to, userId, from)
+	if err != nil {
+		return fmt.Errorf("failed to retag dismissals: %v", err)
+	}
+	return nil
+}
+
+// Renames a tag, keeping its place and favorite status. Use mergeTagDb if the new name already exists.
+func renameTagDb(userId int64, name string, newName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO tags SELECT user_id, ?, favorite, position, last_used FROM tags WHERE user_id=? AND name=?", newName, userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to rename tag %s to %s: %v", name, newName, err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("attempted to rename nonexistent tag: %s", name)
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE user_id=? AND name=?", userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete old tag %s: %v", name, err)
+	}
+	err = retagArticles(tx, userId, name, newName)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Merges a tag into another existing tag, every article tagged with name will be tagged with into instead
+func mergeTagDb(userId int64, name string, into string) error {
+	if name == into {
+		return fmt.Errorf("attempted to merge tag %s into itself", name)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT count(*) > 0 FROM tags WHERE user_id=? AND name=?", userId, into).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("attempted to merge into nonexistent tag: %s", into)
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE user_id=? AND name=?", userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete merged tag %s: %v", name, err)
+	}
+	err = retagArticles(tx, userId, name, into)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Deletes a tag and removes it from every article of the user
+func deleteTagDb(userId int64, name string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM tags WHERE user_id=? AND name=?", userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete tag %s: %v", name, err)
+	}
+	_, err = tx.Exec("UPDATE user_articles SET tags=list_filter(tags, lambda x: x != ?) WHERE user_id=? AND list_contains(tags, ?)", name, userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to remove tag %s from articles: %v", name, err)
+	}
+	_, err = tx.Exec("UPDATE dismissals SET tag=NULL WHERE user_id=? AND tag=?", userId, name)
+	if err != nil {
+		return fmt.Errorf("failed to remove tag %s from dismissals: %v", name, err)
+	}
+	return tx.Commit()
+}
+
+// Moves a tag one place up (offset -1) or down (offset 1) in the tag order
+func moveTagDb(userId int64, name string, offset int) error {
+	tags := tagsDb(userId)
+	index := slices.IndexFunc(tags, func(tag Tag) bool { return tag.Name == name })
+	if index == -1 {
+		return fmt.Errorf("attempted to move nonexistent tag: %s", name)
+	}
+	other := index + offset
+	// tags can't be moved past the start or end, or out of the favorites
+	if other < 0 || other >= len(tags) || tags[other].Favorite != tags[index].Favorite {
+		return nil
+	}
+	tags[index], tags[other] = tags[other], tags[index]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for position, tag := range tags {
+		_, err = tx.Exec("UPDATE tags SET position=? WHERE user_id=? AND name=?", position, userId, tag.Name)
+		if err != nil {
+			return fmt.Errorf("failed to reorder tag %s: %v", tag.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func articleCommentsDb(article_url string) []Comments {
+	rows, err := db.Query("SELECT title, comments FROM comments JOIN feeds ON feed=url WHERE article=?", article_url)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var comments []Comments
+	for rows.Next() {
+		var comment Comments
+		_ = rows.Scan(&comment.Feed, &comment.Url)
+		comments = append(comments, comment)
+	}
+	return comments
+}
+
+// Gets a page of the articles with a tag, newest first
+func taggedArticlesDb(userId int64, tag string, limit int, offset int) []Article {
+	rows, err := db.Query("SELECT url, title, pubdate, tags FROM "+userArticles+" WHERE list_contains(tags, ?) ORDER BY pubdate DESC LIMIT ? OFFSET ?", userId, tag, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var articleList []Article
+	for rows.Next() {
+		var article Article
+		var tagsArr duckdb.Composite[[]string]
+		var date time.Time
+		err = rows.Scan(&article.Url, &article.Title, &date, &tagsArr)
+		if err != nil {
+			panic(err)
+		}
+		article.Tags = tagsArr.Get()
+		article.EscapedUrl = url.QueryEscape(article.Url)
+		article.Date = date.Format(time.RFC1123)
+		article.Comments = articleCommentsDb(article.Url)
+		articleList = append(articleList, article)
+	}
+	return articleList
+}
+
+// Runs an action on many of a user's articles at once in a single transaction, returns the number of articles changed.
+// tag is used by the tag actions, from is the tag replaced by retag.
+func bulkDb(userId int64, urls []string, action string, tag string, from string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var changed int64
+	for _, article := range urls {
+		var res sql.Result
+		switch action {
+		case "mark_read":
+			res, err = tx.Exec("UPDATE user_articles SET read_at=current_localtimestamp() WHERE user_id=? AND article=? AND read_at IS NULL", userId, article)
+		case "mark_unread":
+			res, err = tx.Exec("UPDATE user_articles SET read_at=NULL WHERE user_id=? AND article=? AND read_at IS NOT NULL", userId, article)
+		case "add_tag":
+			res, err = tx.Exec("UPDATE user_articles SET tags=list_append(tags, ?) WHERE user_id=? AND article=? AND NOT list_contains(tags, ?)", tag, userId, article, tag)
+		case "remove_tag":
+			res, err = tx.Exec("UPDATE user_articles SET tags=list_filter(tags, lambda x: x != ?) WHERE user_id=? AND article=? AND list_contains(tags, ?)", tag, userId, article, tag)
+		case "retag":
+			res, err = tx.Exec("UPDATE user_articles SET tags=list_distinct(list_transform(tags, lambda x: CASE WHEN x = ? THEN ? ELSE x END)) WHERE user_id=? AND article=? AND list_contains(tags, ?)", from, tag, userId, article, from)
+		case "archive":
+			// the pages are fetched after the transaction, this just gives dead links another chance
+			res, err = tx.Exec("UPDATE articles SET dead_link=false, fetch_failures=0, next_fetch=NULL WHERE url=? AND url IN (SELECT article FROM user_articles WHERE user_id=?)", article, userId)
+		case "delete":
+			_, err = tx.Exec("DELETE FROM dismissals WHERE user_id=? AND article=?", userId, article)
+			if err != nil {
+				break
+			}
+			res, err = tx.Exec("DELETE FROM user_articles WHERE user_id=? AND article=?", userId, article)
+			if err != nil {
+				break
+			}
+			// the feed still lists the article, so remember it was deleted or the next poll gives it back
+			_, err = tx.Exec("INSERT OR IGNORE INTO deleted_articles SELECT ?, url FROM articles WHERE url=? AND feed IS NOT NULL", userId, article)
+			if err != nil {
+				break
+			}
+			// the article itself is only deleted once nobody has it anymore
+			var kept bool
+			err = tx.QueryRow("SELECT count(*) > 0 FROM user_articles WHERE article=?", article).Scan(&kept)
+			if err != nil || kept {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM comments WHERE article=?", article)
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM dismissals WHERE article=?", article)
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM archive WHERE article=?", article)
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM responses WHERE article=?", article)
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM jobs WHERE article=?", article)
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM articles WHERE url=?", article)
+		default:
+			return 0, fmt.Errorf("unknown bulk action: %s", action)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to %s on %s: %v", action, article, err)
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		changed += rows
+	}
+	if action == "add_tag" || action == "retag" {
+		err = touchTag(tx, userId, tag)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update tag %s: %v", tag, err)
+		}
+	}
+	return changed, tx.Commit()
+}
+
+// Gets the archived copy of an article, returns nil if it hasn't been archived
+func archivedCopyDb(article_url string) (*ArchivedCopy, bool) {
+	var markdown, byline, image, archiveType, asset, assetType sql.NullString
+	var published, archivedAt sql.NullTime
+	var deadLink sql.NullBool
+	var assetSize, width, height sql.NullInt64
+	err := db.QueryRow("SELECT archive, byline, published, image, archived_at, dead_link, archive_type, archive_asset, "+
+		"assets.content_type, octet_length(assets.data), assets.width, assets.height "+
+		"FROM articles LEFT JOIN assets ON assets.hash=archive_asset WHERE url=?", article_url).
+		Scan(&markdown, &byline, &published, &image, &archivedAt, &deadLink, &archiveType, &asset, &assetType, &assetSize, &width, &height)
+	if err != nil {
+		panic(err)
+	}
+	if !markdown.Valid {
+		return nil, deadLink.Bool
+	}
+
+	archive := ArchivedCopy{
+		Type:      archiveType.String,
+		Byline:    byline.String,
+		Image:     image.String,
+		AssetType: assetType.String,
+		AssetSize: assetSize.Int64,
+		Width:     int(width.Int64),
+		Height:    int(height.Int64),
+	}
+	if archive.Type == "" {
+		archive.Type = "html"
+	}
+	if asset.Valid {
+		archive.Asset = "/assets/" + asset.String
+	}
+	err = archive.setContents(markdown.String)
+	if err != nil {
+		slog.Error("unable to render archive", "url", article_url, "error", err)
+		return nil, deadLink.Bool
+	}
+	if published.Valid {
+		archive.Published = published.Time.Format(time.RFC1123)
+	}
+	// archives from before capture times were recorded don't have one
+	if archivedAt.Valid {
+		archive.CapturedAt = archivedAt.Time.Format(time.RFC1123)
+	}
+	return &archive, deadLink.Bool
+}
+
+// Gets every version in an article's archive history, newest first
+func archiveVersionsDb(article_url string) []ArchiveVersion {
+	rows, err := db.Query("SELECT id, captured, coalesce(lag(id) OVER (ORDER BY captured, id), 0), len(string_split_regex(trim(contents), '\\s+')) "+
+		"FROM archive WHERE article=? ORDER BY captured DESC, id DESC", article_url)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var versions []ArchiveVersion
+	for rows.Next() {
+		var version ArchiveVersion
+		var captured time.Time
+		err = rows.Scan(&version.Id, &captured, &version.Previous, &version.Words)
+		if err != nil {
+			panic(err)
+		}
+		version.Captured = captured.Format(time.RFC1123)
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// Gets the contents of a version of an article's archive
+func archiveVersionDb(article_url string, id int64) (string, time.Time, error) {
+	var contents string
+	var captured time.Time
+	err := db.QueryRow("SELECT contents, captured FROM archive WHERE article=? AND id=?", article_url, id).Scan(&contents, &captured)
+	if err != nil {
+		return "", captured, fmt.Errorf("failed to get archive version %d: %v", id, err)
+	}
+	return contents, captured, nil
+}
+
+func linkHealthDb(article_url string) LinkHealth {
+	var health LinkHealth
+	var status, lastError sql.NullString
+	var failures sql.NullInt64
+	var lastChecked, nextFetch sql.NullTime
+	err := db.QueryRow("SELECT link_status, fetch_failures, last_error, last_checked, next_fetch FROM articles WHERE url=?", article_url).
+		Scan(&status, &failures, &lastError, &lastChecked, &nextFetch)
+	if err != nil {
+		panic(err)
+	}
+	health.Status = status.String
+	health.Failures = int(failures.Int64)
+	health.LastError = lastError.String
+	if lastChecked.Valid {
+		health.LastChecked = lastChecked.Time.Format(time.RFC1123)
+	}
+	if nextFetch.Valid {
+		health.NextRetry = nextFetch.Time.Format(time.RFC1123)
+	}
+	return health
+}
+
+// The columns scanned by scanJob
+// Limits jobs to the ones for articles the user has, takes the user's id
+const usersJob = "article IN (SELECT article FROM user_articles WHERE user_id=?) "
+
+const jobColumns = "id, kind, article, coalesce(title, article), status, error, created, started, finished FROM jobs LEFT JOIN articles ON url=article"
+
+func scanJob(row interface{ Scan(...any) error }) (Job, error) {
+	var job Job
+	var jobError sql.NullString
+	var created time.Time
+	var started, finished sql.NullTime
+	err := row.Scan(&job.Id, &job.Kind, &job.Article.Url, &job.Article.Title, &job.Status, &jobError, &created, &started, &finished)
+	if err != nil {
+		return job, err
+	}
+	job.Article.EscapedUrl = url.QueryEscape(job.Article.Url)
+	job.Error = jobError.String
+	job.Created = created.Format(time.RFC1123)
+	if started.Valid {
+		job.Started = started.Time.Format(time.RFC1123)
+	}
+	if finished.Valid {
+		job.Finished = finished.Time.Format(time.RFC1123)
+	}
+	return job, nil
+}
+
+// Gets a job for one of the user's articles
+func jobDb(userId int64, id int64) (Job, error) {
+	return scanJob(db.QueryRow("SELECT "+jobColumns+" WHERE id=? AND "+usersJob, id, userId))
+}
+
+// Gets the pending, running and failed jobs of each queue, with at most limit jobs listed per queue
+// Gets the queues of jobs for the user's articles, jobs for articles only other users have aren't included
+func jobQueuesDb(userId int64, limit int) []JobQueue {
+	var queues []JobQueue
+	for _, kind := range []string{"archive", "refresh"} {
+		queue := JobQueue{Kind: kind}
+		err := db.QueryRow("SELECT count(*) FILTER (WHERE status='pending'), count(*) FILTER (WHERE status='running'), count(*) FILTER (WHERE status='failed') "+
+			"FROM jobs WHERE kind=? AND "+usersJob, kind, userId).Scan(&queue.Pending, &queue.Running, &queue.Failed)
+		if err != nil {
+			panic(err)
+		}
+
+		rows, err := db.Query("SELECT "+jobColumns+" WHERE kind=? AND status IN ('pending', 'running', 'failed') AND "+usersJob+
+			"ORDER BY CASE status WHEN 'running' THEN 0 WHEN 'pending' THEN 1 ELSE 2 END, created, id LIMIT ?", kind, userId, limit)
+		if err != nil {
+			panic(err)
+		}
+		for rows.Next() {
+			job, err := scanJob(rows)
+			if err != nil {
+				panic(err)
+			}
+			queue.Jobs = append(queue.Jobs, job)
+		}
+		rows.Close()
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+// Puts a failed job back in its queue
+func retryJobDb(userId int64, id int64) error {
+	res, err := db.Exec("UPDATE jobs SET status='pending', error=NULL, started=NULL, finished=NULL, created=? WHERE id=? AND status='failed' AND "+usersJob, time.Now(), id, userId)
+	if err != nil {
+		return err
+	}
+	retried, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if retried == 0 {
+		return fmt.Errorf("job %d hasn't failed", id)
+	}
+	return nil
+}
+
+// Gets the archive rules in the order they are checked
+func archiveRulesDb() []ArchiveRule {
+	rows, err := db.Query("SELECT id, match, value, action, max_size FROM archive_rules ORDER BY position, id")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var rules []ArchiveRule
+	for rows.Next() {
+		var rule ArchiveRule
+		var maxSize sql.NullInt64
+		err = rows.Scan(&rule.Id, &rule.Match, &rule.Value, &rule.Action, &maxSize)
+		if err != nil {
+			panic(err)
+		}
+		rule.MaxSize = maxSize.Int64
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Adds a rule after the existing ones
+func addArchiveRuleDb(rule ArchiveRule) error {
+	var maxSize any
+	if rule.MaxSize > 0 {
+		maxSize = rule.MaxSize
+	}
+	_, err := db.Exec("INSERT INTO archive_rules(position, match, value, action, max_size) "+
+		"VALUES ((SELECT coalesce(max(position), -1) + 1 FROM archive_rules), ?, ?, ?, ?)", rule.Match, rule.Value, rule.Action, maxSize)
+	return err
+}
+
+func deleteArchiveRuleDb(id int64) error {
+	_, err := db.Exec("DELETE FROM archive_rules WHERE id=?", id)
+	return err
+}
+
+// Moves a rule earlier (negative offset) or later in the order rules are checked
+func moveArchiveRuleDb(id int64, offset int) error {
+	rules := archiveRulesDb()
+	index := slices.IndexFunc(rules, func(rule ArchiveRule) bool { return rule.Id == id })
+	if index == -1 {
+		return fmt.Errorf("attempted to move nonexistent archive rule: %d", id)
+	}
+	other := index + offset
+	if other < 0 || other >= len(rules) {
+		return nil
+	}
+	rules[index], rules[other] = rules[other], rules[index]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for position, rule := range rules {
+		_, err = tx.Exec("UPDATE archive_rules SET position=? WHERE id=?", position, rule.Id)
+		if err != nil {
+			return fmt.Errorf("failed to reorder archive rule %d: %v", rule.Id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Gets what archive rules match on for an article, and whether it already has an archive
+func archivePolicyInputDb(article_url string) (string, []string, bool, error) {
+	var feed sql.NullString
+	var tags duckdb.Composite[[]string]
+	var archived bool
+	err := db.QueryRow("SELECT feed, "+everyonesTags+", archive IS NOT NULL FROM articles WHERE url=?", article_url).Scan(&feed, &tags, &archived)
+	return feed.String, tags.Get(), archived, err
+}
+
+// The URLs of every feed, suggested as values for archive rules
+func feedUrlsDb() []string {
+	rows, err := db.Query("SELECT url FROM feeds ORDER BY title")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var feed string
+		err = rows.Scan(&feed)
+		if err != nil {
+			panic(err)
+		}
+		urls = append(urls, feed)
+	}
+	return urls
+}
+
+// Whether a query of the form "SELECT count(*) > 0 ..." found anything
+func existsDb(query string, args ...any) (bool, error) {
+	var exists bool
+	err := db.QueryRow(query, args...).Scan(&exists)
+	return exists, err
+}
+
+// Gets a page of articles, newest first. Only unread articles are included if unread is set,
+// and only articles with the tag if tag isn't empty.
+func articlesDb(userId int64, unread bool, tag string, limit int, offset int) []Article {
+	rows, err := db.Query("SELECT url, title, pubdate, tags, read_at IS NOT NULL FROM "+userArticles+" WHERE (NOT ? OR read_at IS NULL) AND (? = '' OR list_contains(tags, ?)) "+
+		"ORDER BY pubdate DESC, url LIMIT ? OFFSET ?", userId, unread, tag, tag, limit, offset)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var articleList []Article
+	for rows.Next() {
+		var article Article
+		var tagsArr duckdb.Composite[[]string]
+		var date time.Time
+		err = rows.Scan(&article.Url, &article.Title, &date, &tagsArr, &article.Read)
+		if err != nil {
+			panic(err)
+		}
+		article.Tags = tagsArr.Get()
+		article.EscapedUrl = url.QueryEscape(article.Url)
+		article.Date = date.Format(time.RFC1123)
+		article.Comments = articleCommentsDb(article.Url)
+		articleList = append(articleList, article)
+	}
+	return articleList
+}
+
+// Builds "?, ?, ?" for a query taking a list of values
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+// Gets the ids and dates of the articles matching a condition from greaderStream, newest first unless oldestFirst is set
+func greaderItemRefsDb(userId int64, condition string, args []any, oldestFirst bool, limit int, offset int) ([]greaderItemRef, error) {
+	order := "DESC"
+	if oldestFirst {
+		order = "ASC"
+	}
+	args = append(append([]any{userId}, args...), limit, offset)
+	rows, err := db.Query("SELECT item_id, coalesce(pubdate, TIMESTAMP '1970-01-01') FROM "+userArticles+" WHERE "+condition+
+		" ORDER BY pubdate "+order+", item_id "+order+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []greaderItemRef{}
+	for rows.Next() {
+		var ref greaderItemRef
+		err = rows.Scan(&ref.Id, &ref.Date)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// Gets the articles with the given item ids in the same order, ids that don't exist are skipped
+func greaderItemsDb(userId int64, ids []int64) ([]greaderItem, error) {
+	if len(ids) == 0 {
+		return []greaderItem{}, nil
+	}
+	args := []any{userId}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.Query("SELECT item_id, articles.url, articles.title, coalesce(pubdate, TIMESTAMP '1970-01-01'), articles.tags, read_at IS NOT NULL, "+
+		"coalesce(feed, ''), coalesce(feeds.title, ''), CASE WHEN archive_type='html' THEN coalesce(archive, '') ELSE '' END "+
+		"FROM "+userArticles+" LEFT JOIN feeds ON feeds.url=feed WHERE item_id IN ("+placeholders(len(ids))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byId := map[int64]greaderItem{}
+	for rows.Next() {
+		var item greaderItem
+		var tagsArr duckdb.Composite[[]string]
+		err = rows.Scan(&item.Id, &item.Url, &item.Title, &item.Date, &tagsArr, &item.Read, &item.Feed, &item.FeedTitle, &item.Content)
+		if err != nil {
+			return nil, err
+		}
+		item.Tags = tagsArr.Get()
+		byId[item.Id] = item
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := []greaderItem{}
+	for _, id := range ids {
+		if item, ok := byId[id]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// Gets the URLs of the user's articles with the given item ids
+func itemUrlsDb(userId int64, ids []int64) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := []any{userId}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.Query("SELECT url FROM "+userArticles+" WHERE item_id IN ("+placeholders(len(ids))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var article string
+		err = rows.Scan(&article)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, article)
+	}
+	return urls, rows.Err()
+}
+
+// Gets the number of unread articles in every feed and tag, and in total under an empty Id and Tag
+func greaderUnreadCountsDb(userId int64) ([]greaderUnreadCount, error) {
+	rows, err := db.Query("SELECT coalesce(feed, ''), '', count(*), max(pubdate) FROM "+userArticles+" WHERE read_at IS NULL AND feed IS NOT NULL GROUP BY feed "+
+		"UNION ALL SELECT '', tag, count(*), max(pubdate) FROM (SELECT unnest(tags) AS tag, pubdate FROM "+userArticles+" WHERE read_at IS NULL) GROUP BY tag "+
+		"UNION ALL SELECT '', '', count(*), max(pubdate) FROM "+userArticles+" WHERE read_at IS NULL", userId, userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []greaderUnreadCount
+	for rows.Next() {
+		var count greaderUnreadCount
+		var newest sql.NullTime
+		err = rows.Scan(&count.Feed, &count.Tag, &count.Count, &newest)
+		if err != nil {
+			return nil, err
+		}
+		count.Newest = newest.Time
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// Marks every unread article of a user matching a condition from greaderStream read, if it was published before the given time
+func markStreamReadDb(userId int64, condition string, args []any, before time.Time) (int64, error) {
+	args = append(append([]any{userId, userId}, args...), before)
+	res, err := db.Exec("UPDATE user_articles SET read_at=current_localtimestamp() WHERE user_id=? AND read_at IS NULL AND article IN "+
+		"(SELECT url FROM "+userArticles+" WHERE ("+condition+") AND pubdate <= ?)", args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Gets the item ids of the user's articles matching a condition, in order
+func itemIdsDb(userId int64, condition string, args ...any) ([]int64, error) {
+	rows, err := db.Query("SELECT item_id FROM "+userArticles+" WHERE "+condition+" ORDER BY item_id", append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func feverFeedsDb(userId int64) ([]feverFeed, error) {
+	rows, err := db.Query("SELECT feed_id, url, title, last_updated, coalesce(folder, '') FROM feeds JOIN subscriptions ON feed=url WHERE user_id=? ORDER BY feed_id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []feverFeed{}
+	for rows.Next() {
+		var f feverFeed
+		var updated sql.NullTime
+		err = rows.Scan(&f.Id, &f.Url, &f.Title, &updated, &f.Folder)
+		if err != nil {
+			return nil, err
+		}
+		f.Updated = updated.Time
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+// Gets up to limit articles matching a condition from feverItems, ordered by item id
+func feverItemsDb(userId int64, condition string, args []any, descending bool, limit int) ([]feverItem, error) {
+	order := "ASC"
+	if descending {
+		order = "DESC"
+	}
+	args = append([]any{savedTag, userId}, append(args, limit)...)
+	rows, err := db.Query("SELECT item_id, coalesce(feeds.feed_id, 0), articles.title, coalesce(byline, ''), "+
+		"CASE WHEN archive_type='html' THEN coalesce(archive, '') ELSE '' END, articles.url, list_contains(articles.tags, ?), read_at IS NOT NULL, "+
+		"coalesce(pubdate, TIMESTAMP '1970-01-01') FROM "+userArticles+" LEFT JOIN feeds ON feeds.url=articles.feed "+
+		"WHERE "+condition+" ORDER BY item_id "+order+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []feverItem{}
+	for rows.Next() {
+		var item feverItem
+		var saved, read bool
+		var date time.Time
+		err = rows.Scan(&item.Id, &item.FeedId, &item.Title, &item.Author, &item.Html, &item.Url, &saved, &read, &date)
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			item.IsSaved = 1
+		}
+		if read {
+			item.IsRead = 1
+		}
+		item.CreatedOnTime = date.Unix()
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Gets the number of articles a user has and when their feeds were last fetched, zero if never
+func feverStatusDb(userId int64) (int, time.Time, error) {
+	var total int
+	var refreshed sql.NullTime
+	err := db.QueryRow("SELECT (SELECT count(*) FROM user_articles WHERE user_id=?), "+
+		"(SELECT max(last_updated) FROM feeds JOIN subscriptions ON feed=url WHERE user_id=?)", userId, userId).Scan(&total, &refreshed)
+	return total, refreshed.Time, err
+}
+
+const userColumns = "users.id, users.name, users.admin"
+
+// The favorite tags new users start with
+var defaultFavoriteTags = []string{"later", "favorite", "reference", "archive"}
+
+func createUserDb(name string, passwordHash string, feverKey string, admin bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("INSERT INTO users(name, password_hash, fever_key, admin, created) VALUES (?, ?, ?, ?, current_localtimestamp()) RETURNING id",
+		name, passwordHash, feverKey, admin).Scan(&id)
+	if err != nil {
+		return err
+	}
+	for position, tag := range defaultFavoriteTags {
+		_, err = tx.Exec("INSERT INTO tags VALUES (?, ?, true, ?, NULL)", id, tag, position)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Gives the user without a password, which has the data from before there were accounts, a name and password.
+// Returns false if there isn't one.
+func claimDefaultUserDb(name string, passwordHash string, feverKey string) (bool, error) {
+	res, err := db.Exec("UPDATE users SET name=?, password_hash=?, fever_key=?, admin=true WHERE password_hash=''", name, passwordHash, feverKey)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := res.RowsAffected()
+	return claimed > 0, err
+}
+
+// Gets every user, for admins to manage
+func usersDb() ([]User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users WHERE password_hash != '' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.Id, &user.Name, &user.Admin)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Counts the users that can log in
+func userCountDb() (int, error) {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM users WHERE password_hash != ''").Scan(&count)
+	return count, err
+}
+
+// Gets a user and their password hash by name, returns sql.ErrNoRows if there isn't one
+func userByNameDb(name string) (User, string, error) {
+	var user User
+	var passwordHash string
+	err := db.QueryRow("SELECT "+userColumns+", password_hash FROM users WHERE name=?", name).Scan(&user.Id, &user.Name, &user.Admin, &passwordHash)
+	return user, passwordHash, err
+}
+
+// Gets the user with a Fever API key, returns sql.ErrNoRows if there isn't one
+func userByFeverKeyDb(key string) (User, error) {
+	var user User
+	err := db.QueryRow("SELECT "+userColumns+" FROM users WHERE fever_key=? AND password_hash != ''", key).Scan(&user.Id, &user.Name, &user.Admin)
+	return user, err
+}
+
+func createSessionDb(tokenHash string, userId int64, kind string, duration time.Duration) error {
+	// expired sessions are only cleaned up when new ones are made
+	_, err := db.Exec("DELETE FROM sessions WHERE expires < current_localtimestamp()")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO sessions VALUES (?, ?, ?, current_localtimestamp(), current_localtimestamp() + to_seconds(?))",
+		tokenHash, userId, kind, int64(duration.Seconds()))
+	return err
+}
+
+// Gets the user of a session that hasn't expired, returns sql.ErrNoRows if there isn't one
+func sessionUserDb(tokenHash string, kind string) (User, error) {
+	var user User
+	err := db.QueryRow("SELECT "+userColumns+" FROM sessions JOIN users ON users.id=user_id "+
+		"WHERE token_hash=? AND kind=? AND expires > current_localtimestamp()", tokenHash, kind).Scan(&user.Id, &user.Name, &user.Admin)
+	return user, err
+}
+
+func deleteSessionDb(tokenHash string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE token_hash=?", tokenHash)
+	return err
+}
+
+// Stores a token that expires after the given number of days, or never if days is 0
+func createApiTokenDb(userId int64, name string, tokenHash string, scopes []string, days int) error {
+	var expiresIn any
+	if days > 0 {
+		expiresIn = days
+	}
+	_, err := db.Exec("INSERT INTO api_tokens(user_id, name, token_hash, scopes, created, expires) "+
+		"VALUES (?, ?, ?, string_split(?, ','), current_localtimestamp(), current_localtimestamp() + to_days(CAST(? AS INTEGER)))",
+		userId, name, tokenHash, strings.Join(scopes, ","), expiresIn)
+	return err
+}
+
+// Gets a user's tokens, newest first
+func apiTokensDb(userId int64) ([]ApiToken, error) {
+	rows, err := db.Query("SELECT id, name, scopes, created, expires, last_used FROM api_tokens WHERE user_id=? ORDER BY created DESC, id DESC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []ApiToken
+	for rows.Next() {
+		var token ApiToken
+		var scopes duckdb.Composite[[]string]
+		var created time.Time
+		var expires, lastUsed sql.NullTime
+		err = rows.Scan(&token.Id, &token.Name, &scopes, &created, &expires, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = scopes.Get()
+		token.Created = created.Format(time.RFC1123)
+		if expires.Valid {
+			token.Expires = expires.Time.Format(time.RFC1123)
+			token.Expired = expires.Time.Before(time.Now())
+		}
+		if lastUsed.Valid {
+			token.LastUsed = lastUsed.Time.Format(time.RFC1123)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Gets the user and scopes of a token that hasn't expired, recording that it was used.
+// Returns sql.ErrNoRows if there isn't one.
+func apiTokenUserDb(tokenHash string) (User, []string, error) {
+	var user User
+	var id int64
+	var scopes duckdb.Composite[[]string]
+	err := db.QueryRow("SELECT "+userColumns+", api_tokens.id, scopes FROM api_tokens JOIN users ON users.id=user_id "+
+		"WHERE token_hash=? AND (expires IS NULL OR expires > current_localtimestamp())", tokenHash).Scan(&user.Id, &user.Name, &user.Admin, &id, &scopes)
+	if err != nil {
+		return user, nil, err
+	}
+	_, err = db.Exec("UPDATE api_tokens SET last_used=current_localtimestamp() WHERE id=?", id)
+	return user, scopes.Get(), err
+}
+
+func revokeApiTokenDb(userId int64, id int64) error {
+	_, err := db.Exec("DELETE FROM api_tokens WHERE user_id=? AND id=?", userId, id)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Articles only remembered their feed from migration 13 on, and then only if they had comments
+func TestUpgradeFillsInFeed(t *testing.T) {
+	feed := serveRss(t)
+	const first = "https://blog.example.org/first"
+	const second = "https://blog.example.org/second"
+
+	path := filepath.Join(t.TempDir(), "data.db")
+	old, err := sql.Open("duckdb", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= 12; i++ {
+		err = runMigration(old, fmt.Sprintf("migrations/%d.sql", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = old.Exec("INSERT INTO feeds(url, title, description, tags) VALUES (?, 'Example Blog', '', [])", feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec("INSERT INTO articles(url, title, pubdate, tags, dead_link, fetch_failures) VALUES "+
+		"(?, 'First post', TIMESTAMP '2026-01-01 12:00:00', [], false, 0), (?, 'Second post', TIMESTAMP '2026-01-02 12:00:00', [], false, 0)", first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec("INSERT INTO comments VALUES (?, ?, 'https://news.example.net/comments')", second, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	upgraded, err := openDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = upgraded
+	t.Cleanup(func() { upgraded.Close(); db = previous })
+
+	articleFeed := func(article string) string {
+		t.Helper()
+		var articleFeed sql.NullString
+		err := db.QueryRow("SELECT feed FROM articles WHERE url=?", article).Scan(&articleFeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return articleFeed.String
+	}
+	if articleFeed(first) != "" || articleFeed(second) != feed {
+		t.Fatalf("migrated feeds are %q and %q", articleFeed(first), articleFeed(second))
+	}
+
+	update_feed(db, feed)
+	if articleFeed(first) != feed {
+		t.Errorf("polling the feed didn't fill in the feed of an article without comments, got %q", articleFeed(first))
+	}
+
+	// new subscribers get it along with the rest of the feed
+	bob, _ := testUser(t, "bob")
+	err = subscribeDb(bob.Id, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasArticle(t, bob.Id, first) || !hasArticle(t, bob.Id, second) {
+		t.Error("a new subscriber didn't get the articles from before the upgrade")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// A line in a diff between two archive versions
+type DiffLine struct {
+	// One of "same", "added" or "removed"
+	Kind string
+	Text string
+}
+
+// Diffs larger than this many lines (after removing the common start and end) are shown as a full replacement,
+// since the longest common subsequence table grows with the square of the length
+const maxDiffLines = 5000
+
+// Finds the lines added and removed between two texts using the longest common subsequence of their lines
+func diffLines(before string, after string) []DiffLine {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	var diff []DiffLine
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		diff = append(diff, DiffLine{"same", a[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	aMiddle := a[prefix : len(a)-suffix]
+	bMiddle := b[prefix : len(b)-suffix]
+
+	if len(aMiddle)+len(bMiddle) > maxDiffLines {
+		for _, line := range aMiddle {
+			diff = append(diff, DiffLine{"removed", line})
+		}
+		for _, line := range bMiddle {
+			diff = append(diff, DiffLine{"added", line})
+		}
+	} else {
+		diff = append(diff, lcsDiff(aMiddle, bMiddle)...)
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, DiffLine{"same", line})
+	}
+	return diff
+}
+
+func lcsDiff(a []string, b []string) []DiffLine {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int32, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			diff = append(diff, DiffLine{"same", a[i]})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			diff = append(diff, DiffLine{"removed", a[i]})
+			i++
+		} else {
+			diff = append(diff, DiffLine{"added", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{"removed", a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{"added", b[j]})
+	}
+	return diff
+}
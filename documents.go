@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html/charset"
+)
+
+// Documents can be much larger than web pages
+const maxDocumentSize = 50 * 1024 * 1024
+
+// Whether a media type can be archived by save_archive or save_document
+func archivable(media_type string) bool {
+	switch {
+	case media_type == "text/html", media_type == "application/xhtml+xml":
+		return true
+	case media_type == "text/plain", media_type == "text/markdown", media_type == "application/pdf":
+		return true
+	case strings.HasPrefix(media_type, "image/"):
+		return true
+	}
+	return false
+}
+
+// Archives a file that isn't an HTML page. PDFs and images are kept as assets,
+// with the text of PDFs stored as the archive so they can be searched.
+func save_document(db *sql.DB, url string, media_type string, content_type string, body []byte, captured time.Time) error {
+	var archive_type, text, asset string
+	switch {
+	case media_type == "application/pdf":
+		hash, err := addAssetDb(db, body, media_type, url)
+		if err != nil {
+			return fmt.Errorf("unable to store pdf: %v", err)
+		}
+		text, err = pdfText(body)
+		if err != nil {
+			// the pdf can still be read without its text, it just can't be searched
+			slog.Error("unable to extract text from pdf", "url", url, "error", err)
+		}
+		archive_type, asset = "pdf", hash
+	case strings.HasPrefix(media_type, "image/"):
+		hash, err := addAssetDb(db, body, media_type, url)
+		if err != nil {
+			return fmt.Errorf("unable to store image: %v", err)
+		}
+		archive_type, asset = "image", hash
+		text = "![](/assets/" + hash + ")"
+	case strings.HasPrefix(media_type, "text/"):
+		decoded, err := charset.NewReader(bytes.NewReader(body), content_type)
+		if err != nil {
+			return fmt.Errorf("unable to decode text: %v", err)
+		}
+		decodedText, err := io.ReadAll(decoded)
+		if err != nil {
+			return fmt.Errorf("unable to decode text: %v", err)
+		}
+		archive_type, text = "text", string(decodedText)
+	default:
+		return fmt.Errorf("unable to archive %s", media_type)
+	}
+
+	var assetValue any
+	if asset != "" {
+		assetValue = asset
+	}
+	_, err := db.Exec("UPDATE articles SET archive=?, archive_type=?, archive_asset=?, byline=NULL, published=NULL, image=NULL, archived_at=? WHERE url=?",
+		text, archive_type, assetValue, captured, url)
+	if err != nil {
+		return fmt.Errorf("unable to add archive to db: %v", err)
+	}
+	return save_version(db, url, text, captured)
+}
+
+// Extracts the text of a PDF, one paragraph per page
+func pdfText(body []byte) (text string, err error) {
+	// the pdf parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to parse pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", err
+	}
+	var pages []string
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", err
+		}
+		pages = append(pages, strings.TrimSpace(pageText))
+	}
+	return strings.Join(pages, "\n\n"), nil
+}
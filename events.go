@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
-	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"github.com/marcboeker/go-duckdb/v2"
 	"github.com/mmcdole/gofeed/rss"
+	"golang.org/x/net/html/charset"
 )
 
 // Updates every feed currently in the databse
@@ -30,8 +37,6 @@ func update_feeds(db *sql.DB) {
 
 // Updates a single feed
 func update_feed(db *sql.DB, url string) {
-	client := &http.Client{}
-
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		println(err.Error())
@@ -51,7 +56,7 @@ func update_feed(db *sql.DB, url string) {
 		req.Header.Add("If-Modified-Since", last_updated.Format(time.RFC1123Z))
 	}
 
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		slog.Error("unable to get feed", "feed", url, "error", err.Error())
 		return
@@ -63,12 +68,12 @@ func update_feed(db *sql.DB, url string) {
 	}
 
 	fp := rss.Parser{}
-	feed, err := fp.Parse(resp.Body)
+	feed, err := fp.Parse(io.LimitReader(resp.Body, maxFeedSize))
 	if err != nil {
 		slog.Error("unable to parse feed", "feed", url, "error", err.Error())
 	}
 	// update the title, description, and update time of the feed
-	_, err = db.Query("INSERT INTO feeds VALUES(?, ?, ?, current_localtimestamp(), []) "+
+	_, err = db.Query("INSERT INTO feeds(url, title, description, last_updated, tags, feed_id) VALUES(?, ?, ?, current_localtimestamp(), [], nextval('feed_id')) "+
 		"ON CONFLICT DO UPDATE SET title=EXCLUDED.title, description=EXCLUDED.description, last_updated=EXCLUDED.last_updated",
 		url, feed.Title, feed.Description)
 	if err != nil {
@@ -89,11 +94,14 @@ func update_feed(db *sql.DB, url string) {
 			Tags:       []string{},
 		}
 
-		err = addArticleDb(article)
+		added, err := addArticleDb(article, url)
 		if err != nil {
 			slog.Error("unable to add article", "feed", url, "article", item.Link, "error", err.Error())
 			continue
 		}
+		if added {
+			apply_archive_policy(db, item.Link)
+		}
 
 		if len(item.Comments) == 0 {
 			continue
@@ -107,67 +115,425 @@ func update_feed(db *sql.DB, url string) {
 	}
 }
 
+// Queues the articles that haven't been archived yet and match an archive rule
 func archive_pages(db *sql.DB) {
-	rows, err := db.Query("SELECT url FROM articles WHERE archive IS NULL AND length(articles.tags) > 0 AND NOT dead_link")
+	retry_robots_skipped(db)
+	urls := archive_candidates(db, "SELECT url, feed, "+everyonesTags+" FROM articles WHERE archive IS NULL AND NOT dead_link AND link_status IS DISTINCT FROM 'skipped' AND (next_fetch IS NULL OR next_fetch <= ?)", time.Now())
+
+	for _, url := range urls {
+		_, err := queue_job(db, "archive", url)
+		if err != nil {
+			slog.Error("unable to queue article to be archived", "url", url, "error", err)
+		}
+	}
+}
+
+// Lets articles robots.txt didn't allow archiving be queued again if their site's robots.txt has changed since
+func retry_robots_skipped(db *sql.DB) {
+	rows, err := db.Query("SELECT url, robots_hash FROM articles WHERE archive IS NULL AND link_status='skipped' AND robots_hash IS NOT NULL")
 	if err != nil {
-		println(err.Error())
-		panic("unable to get articles to be archived")
+		slog.Error("unable to get articles robots.txt didn't allow", "error", err)
+		return
 	}
+	var changed []string
+	for rows.Next() {
+		var url, hash string
+		err = rows.Scan(&url, &hash)
+		if err != nil {
+			slog.Error("error scanning articles robots.txt didn't allow", "error", err)
+			rows.Close()
+			return
+		}
+		// robots.txt is cached, so this only fetches it about once a day for each site
+		if _, current := robotsAllowed(url); current != hash {
+			changed = append(changed, url)
+		}
+	}
+	rows.Close()
+
+	for _, url := range changed {
+		_, err = db.Exec("UPDATE articles SET link_status=NULL, last_error=NULL, robots_hash=NULL WHERE url=?", url)
+		if err != nil {
+			slog.Error("unable to retry article robots.txt didn't allow", "url", url, "error", err)
+		}
+	}
+}
+
+// Gets the URLs of the articles selected by query that match an archive rule which doesn't say never
+func archive_candidates(db *sql.DB, query string, args ...any) []string {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("unable to get articles to be archived", "error", err)
+		return nil
+	}
+	defer rows.Close()
+
+	rules := archiveRulesDb()
+	var urls []string
 	for rows.Next() {
 		var url string
-		err = rows.Scan(&url)
+		var feed sql.NullString
+		var tags duckdb.Composite[[]string]
+		err = rows.Scan(&url, &feed, &tags)
 		if err != nil {
-			println(err.Error())
-			panic("error scanning articles to be archived")
+			slog.Error("error scanning articles to be archived", "error", err)
+			return nil
 		}
-		resp, err := http.Get(url)
+		rule := matchArchiveRule(rules, url, feed.String, tags.Get())
+		if rule != nil && rule.Action != "never" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Fetches a single article and stores it as markdown, or as a document if it isn't a web page, recording the failure if it can't be fetched
+func archive_page(db *sql.DB, url string) error {
+	// this isn't a problem with the link, so it isn't recorded as a failed fetch. It is remembered so the page
+	// isn't tried again until robots.txt changes, see retry_robots_skipped.
+	allowed, robotsHash := robotsAllowed(url)
+	if !allowed {
+		_, err := db.Exec("UPDATE articles SET robots_hash=? WHERE url=?", robotsHash, url)
 		if err != nil {
-			slog.Error("unable to get article", "url", url, "error", err)
-			_, err = db.Exec("UPDATE articles SET dead_link=true WHERE url=?", url)
-			if err != nil {
-				slog.Error("unable to mark dead link in db", "error", err, "url", url)
-				continue
-			}
-			continue
+			slog.Error("unable to record robots.txt in db", "url", url, "error", err)
 		}
-		if resp.StatusCode != http.StatusOK {
+		return skip_archive(db, url, "robots.txt doesn't allow archiving this page")
+	}
 
-			slog.Error("unable to get article", "url", url, "status", resp.StatusCode)
-			_, err = db.Exec("UPDATE articles SET dead_link=true WHERE url=?", url)
-			if err != nil {
-				slog.Error("unable to mark dead link in db", "error", err, "url", url)
-				continue
-			}
-			continue
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		record_fetch(db, url, 0, err)
+		return fmt.Errorf("unable to get article: %v", err)
+	}
+	defer resp.Body.Close()
+	record_fetch(db, url, resp.StatusCode, nil)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get article: %s", resp.Status)
+	}
+
+	content_type := resp.Header.Get("content-type")
+	media_type, _, err := mime.ParseMediaType(content_type)
+	if err != nil || !archivable(media_type) {
+		return skip_archive(db, url, fmt.Sprintf("link is not a page or document that can be archived: %q", content_type))
+	}
+	limit := archiveSizeLimit(url)
+	if resp.ContentLength > limit {
+		return skip_archive(db, url, fmt.Sprintf("link is too large to archive: %d bytes", resp.ContentLength))
+	}
+
+	body, err := readLimited(resp.Body, limit)
+	if errors.Is(err, errTooLarge) {
+		return skip_archive(db, url, fmt.Sprintf("link is too large to archive: %v", err))
+	} else if err != nil {
+		return fmt.Errorf("unable to read body of article: %v", err)
+	}
+
+	if keepResponses {
+		err = save_response(db, url, resp, body)
+		if err != nil {
+			slog.Error("unable to save raw response", "url", url, "error", err)
 		}
+	}
 
-		content_type := resp.Header.Get("content-type")
-		if !strings.Contains(content_type, "text/html") {
-			slog.Error("link is not HTML, not archiving", "url", url, "content-type", content_type)
-			continue
+	if media_type == "text/html" || media_type == "application/xhtml+xml" {
+		return save_archive(db, url, resp.Request.URL.String(), content_type, body, time.Now())
+	}
+	return save_document(db, url, media_type, content_type, body, time.Now())
+}
+
+// Records that an article can't be archived for a reason trying again won't change, so it isn't queued again
+// by itself. It can still be archived by hand. Returns the reason as the error of the archive job.
+func skip_archive(db *sql.DB, url string, reason string) error {
+	_, err := db.Exec("UPDATE articles SET link_status='skipped', last_error=?, last_checked=? WHERE url=?", reason, time.Now(), url)
+	if err != nil {
+		slog.Error("unable to record skipped article in db", "url", url, "error", err)
+	}
+	return errors.New(reason)
+}
+
+// Extracts the content of a fetched HTML page and stores it as the article's archive.
+// pageUrl is where the page was actually fetched from, after redirects.
+func save_archive(db *sql.DB, url string, pageUrl string, content_type string, body []byte, captured time.Time) error {
+	decoded, err := charset.NewReader(bytes.NewReader(body), content_type)
+	if err != nil {
+		return fmt.Errorf("unable to decode body: %v", err)
+	}
+
+	extracted, err := extractArticle(decoded, pageUrl)
+	if err != nil {
+		return fmt.Errorf("unable to extract article: %v", err)
+	}
+
+	markdown := archiveAssets(db, extracted.Markdown)
+	image := ""
+	if extracted.Image != "" {
+		image = archiveAsset(db, extracted.Image)
+		if image == "" {
+			image = extracted.Image
 		}
+	}
 
-		body, err := io.ReadAll(resp.Body)
+	var published any
+	if !extracted.Published.IsZero() {
+		published = extracted.Published
+	}
+	// articles only have their URL as the title when nothing better was known, like bookmarks of pages without one
+	_, err = db.Exec("UPDATE articles SET archive=?, archive_type='html', archive_asset=NULL, byline=?, published=?, image=?, archived_at=?, "+
+		"title=CASE WHEN title=url AND ? != '' THEN ? ELSE title END WHERE url=?",
+		markdown, extracted.Byline, published, image, captured, extracted.Title, extracted.Title, url)
+	if err != nil {
+		return fmt.Errorf("unable to add archive to db: %v", err)
+	}
+	return save_version(db, url, markdown, captured)
+}
+
+// Adds a new version to the article's archive history, unless it is the same as the latest version
+func save_version(db *sql.DB, url string, markdown string, captured time.Time) error {
+	sum := sha256.Sum256([]byte(markdown))
+	hash := hex.EncodeToString(sum[:])
+
+	var latest string
+	err := db.QueryRow("SELECT hash FROM archive WHERE article=? ORDER BY captured DESC LIMIT 1", url).Scan(&latest)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("unable to get latest archive version: %v", err)
+	}
+	if latest == hash {
+		return nil
+	}
+
+	_, err = db.Exec("INSERT INTO archive(article, contents, hash, captured) VALUES (?, ?, ?, ?)", url, markdown, hash, captured)
+	if err != nil {
+		return fmt.Errorf("unable to add archive version: %v", err)
+	}
+	return nil
+}
+
+// Transient failures are retried after retryBase, doubling each time up to retryMax.
+// After maxFetchFailures in a row the link is considered dead.
+const retryBase = time.Hour
+const retryMax = 7 * 24 * time.Hour
+const maxFetchFailures = 8
+
+// Sorts the result of fetching a link into "ok", "gone" if the page was deleted,
+// or "error" if it might work if we try again later
+func classify_fetch(status int, err error) string {
+	switch {
+	case err != nil:
+		// timeouts, DNS and connection errors are usually temporary
+		return "error"
+	case status >= 200 && status < 300:
+		return "ok"
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return "gone"
+	default:
+		// 5xx, 429 and other statuses
+		return "error"
+	}
+}
+
+// Records the result of fetching a link, scheduling a retry with exponential backoff for transient failures
+func record_fetch(db *sql.DB, url string, status int, fetchErr error) {
+	link_status := classify_fetch(status, fetchErr)
+	var err error
+	switch link_status {
+	case "ok":
+		_, err = db.Exec("UPDATE articles SET link_status='ok', dead_link=false, fetch_failures=0, next_fetch=NULL, last_error=NULL, robots_hash=NULL, last_checked=? WHERE url=?", time.Now(), url)
+	case "gone":
+		_, err = db.Exec("UPDATE articles SET link_status='gone', dead_link=true, last_error=?, last_checked=? WHERE url=?", http.StatusText(status), time.Now(), url)
+	case "error":
+		message := http.StatusText(status)
+		if fetchErr != nil {
+			message = fetchErr.Error()
+		}
+		var failures int
+		err = db.QueryRow("SELECT coalesce(fetch_failures, 0) + 1 FROM articles WHERE url=?", url).Scan(&failures)
 		if err != nil {
-			slog.Error("unable to read body of article", "url", url)
-			continue
+			break
 		}
+		// the shift is capped so it can't overflow, the delay is already past retryMax by then
+		retry := min(retryBase<<min(failures-1, 16), retryMax)
+		_, err = db.Exec("UPDATE articles SET link_status='error', fetch_failures=?, next_fetch=?, dead_link=?, last_error=?, last_checked=? WHERE url=?",
+			failures, time.Now().Add(retry), failures >= maxFetchFailures, message, time.Now(), url)
+	}
+	if err != nil {
+		slog.Error("unable to record fetch result in db", "url", url, "status", link_status, "error", err)
+	}
+}
 
-		markdown, err := htmltomarkdown.ConvertString(string(body))
+// How often archived links are checked to see if they still work
+const linkCheckInterval = 7 * 24 * time.Hour
+
+// Checks that the links of archived articles still work, flagging the ones that have died since they were archived.
+// Failing links are checked again when their backoff is up, the rest every linkCheckInterval.
+func check_links(db *sql.DB) {
+	rows, err := db.Query("SELECT url FROM articles WHERE archive IS NOT NULL AND CASE WHEN link_status='error' THEN coalesce(next_fetch <= ?, true) "+
+		"ELSE last_checked IS NULL OR last_checked < ? END",
+		time.Now(), time.Now().Add(-linkCheckInterval))
+	if err != nil {
+		slog.Error("unable to get links to check", "error", err)
+		return
+	}
+	var urls []string
+	for rows.Next() {
+		var url string
+		err = rows.Scan(&url)
 		if err != nil {
-			slog.Error("unable to convert to markdown", "error", err, "url", url)
-			continue
+			slog.Error("error scanning links to check", "error", err)
+			rows.Close()
+			return
+		}
+		urls = append(urls, url)
+	}
+	rows.Close()
+
+	for _, url := range urls {
+		resp, err := httpClient.Head(url)
+		// plenty of servers don't support HEAD
+		if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+			resp.Body.Close()
+			resp, err = httpClient.Get(url)
 		}
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+		}
+		record_fetch(db, url, status, err)
+		if classify_fetch(status, err) != "ok" {
+			slog.Info("archived link is failing", "url", url, "status", status, "error", err)
+		}
+	}
+}
 
-		_, err = db.Exec("UPDATE articles SET archive=? WHERE url=?", markdown, url)
+// How long to wait before archiving a tagged article again to check for changes
+const rearchiveInterval = 7 * 24 * time.Hour
+
+// Queues archived articles that still match an archive rule to be archived again, so edits to them are kept as new versions
+func rearchive_pages(db *sql.DB) {
+	urls := archive_candidates(db, "SELECT url, feed, "+everyonesTags+" FROM articles WHERE archive IS NOT NULL AND NOT dead_link AND link_status IS DISTINCT FROM 'skipped' AND archived_at < ? AND (next_fetch IS NULL OR next_fetch <= ?)",
+		time.Now().Add(-rearchiveInterval), time.Now())
+
+	for _, url := range urls {
+		_, err := queue_job(db, "refresh", url)
 		if err != nil {
-			slog.Error("unable to add archive to db", "error", err, "url", url)
-			continue
+			slog.Error("unable to queue article to be archived again", "url", url, "error", err)
 		}
-		_, err = db.Exec("UPDATE articles SET dead_link=FALSE WHERE url=?", url)
+	}
+}
+
+// Stores the raw HTTP response an article was archived from so it can be exported to WARC
+func save_response(db *sql.DB, url string, resp *http.Response, body []byte) error {
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	header := resp.Header.Clone()
+	// the body has already been decompressed and unchunked by the http client
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	err := header.Write(&raw)
+	if err != nil {
+		return err
+	}
+	raw.WriteString("\r\n")
+	raw.Write(body)
+
+	_, err = db.Exec("INSERT OR REPLACE INTO responses VALUES (?, ?, current_localtimestamp())", url, raw.Bytes())
+	return err
+}
+
+// Wakes up run_jobs when a job is queued, so it doesn't wait for its next check
+var jobQueued = make(chan struct{}, 1)
+
+// Done jobs are only kept long enough for the "Archive now" button to see they finished
+const doneJobRetention = 24 * time.Hour
+
+// Adds a job for an article to its queue and returns its id.
+// If the article already has a pending or running job of the same kind, that job's id is returned instead.
+// A failed job of the same kind is queued again rather than adding another, so failures don't pile up.
+func queue_job(db *sql.DB, kind string, url string) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT id FROM jobs WHERE kind=? AND article=? AND status IN ('pending', 'running')", kind, url).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	err = db.QueryRow("UPDATE jobs SET status='pending', error=NULL, started=NULL, finished=NULL, created=? "+
+		"WHERE id=(SELECT max(id) FROM jobs WHERE kind=? AND article=? AND status='failed') RETURNING id", time.Now(), kind, url).Scan(&id)
+	if err == nil {
+		wake_jobs()
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	err = db.QueryRow("INSERT INTO jobs (kind, article, status, created) VALUES (?, ?, 'pending', ?) RETURNING id", kind, url, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	wake_jobs()
+	return id, nil
+}
+
+func wake_jobs() {
+	select {
+	case jobQueued <- struct{}{}:
+	default:
+	}
+}
+
+// Runs queued jobs one at a time, oldest first, forever
+func run_jobs(db *sql.DB) {
+	// jobs that were running when the server stopped never finished
+	_, err := db.Exec("UPDATE jobs SET status='pending', started=NULL WHERE status='running'")
+	if err != nil {
+		slog.Error("unable to requeue interrupted jobs", "error", err)
+	}
+
+	for {
+		ran, err := run_next_job(db)
 		if err != nil {
-			slog.Error("unable to add archive to db", "error", err, "url", url)
+			slog.Error("unable to run job", "error", err)
+		}
+		if ran {
 			continue
 		}
+
+		_, err = db.Exec("DELETE FROM jobs WHERE status='done' AND finished < ?", time.Now().Add(-doneJobRetention))
+		if err != nil {
+			slog.Error("unable to remove old jobs", "error", err)
+		}
+		select {
+		case <-jobQueued:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// Runs the oldest pending job, returns false if there weren't any
+func run_next_job(db *sql.DB) (bool, error) {
+	var id int64
+	var kind, url string
+	err := db.QueryRow("SELECT id, kind, article FROM jobs WHERE status='pending' ORDER BY created, id LIMIT 1").Scan(&id, &kind, &url)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = db.Exec("UPDATE jobs SET status='running', started=? WHERE id=?", time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	// both queues archive the page, they are kept apart so new articles can be told apart from refreshes
+	jobErr := archive_page(db, url)
+	if jobErr != nil {
+		slog.Error("unable to archive article", "url", url, "job", kind, "error", jobErr)
+		_, err = db.Exec("UPDATE jobs SET status='failed', error=?, finished=? WHERE id=?", jobErr.Error(), time.Now(), id)
+	} else {
+		_, err = db.Exec("UPDATE jobs SET status='done', error=NULL, finished=? WHERE id=?", time.Now(), id)
 	}
+	return true, err
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRss = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Example Blog</title>
+<link>https://blog.example.org/</link>
+<description>Posts</description>
+<item><title>First post</title><link>https://blog.example.org/first</link><pubDate>Thu, 01 Jan 2026 12:00:00 GMT</pubDate></item>
+<item><title>Second post</title><link>https://blog.example.org/second</link><pubDate>Fri, 02 Jan 2026 12:00:00 GMT</pubDate></item>
+</channel>
+</rss>`
+
+// Serves testRss and returns the URL of the feed
+func serveRss(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(testRss))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/feed.xml"
+}
+
+// Serves testRss as a feed and subscribes the users to it
+func testFeed(t *testing.T, userIds ...int64) string {
+	t.Helper()
+	feed := serveRss(t)
+	err := addFeedDb(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range userIds {
+		err = subscribeDb(userId, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return feed
+}
+
+func hasArticle(t *testing.T, userId int64, article string) bool {
+	t.Helper()
+	found, err := existsDb("SELECT count(*) > 0 FROM user_articles WHERE user_id=? AND article=?", userId, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestDeletedFeedArticleStaysDeleted(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	bob, _ := testUser(t, "bob")
+	feed := testFeed(t, alice.Id, bob.Id)
+	const first = "https://blog.example.org/first"
+
+	update_feed(db, feed)
+	if !hasArticle(t, alice.Id, first) || !hasArticle(t, bob.Id, first) {
+		t.Fatal("polling the feed didn't add the article")
+	}
+
+	_, err := bulkDb(alice.Id, []string{first}, "delete", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	update_feed(db, feed)
+	if hasArticle(t, alice.Id, first) {
+		t.Error("polling the feed again gave back the deleted article")
+	}
+	if !hasArticle(t, bob.Id, first) {
+		t.Error("deleting the article took it from another subscriber")
+	}
+
+	// once every subscriber deleted it the article itself is gone, and stays gone
+	_, err = bulkDb(bob.Id, []string{first}, "delete", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	update_feed(db, feed)
+	if hasArticle(t, alice.Id, first) || hasArticle(t, bob.Id, first) {
+		t.Error("polling the feed again gave back the article everyone deleted")
+	}
+	exists, err := existsDb("SELECT count(*) > 0 FROM articles WHERE url=?", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("polling the feed again added back the article everyone deleted")
+	}
+	if !hasArticle(t, alice.Id, "https://blog.example.org/second") {
+		t.Error("the article that wasn't deleted is gone")
+	}
+
+	// subscribing again doesn't give it back either
+	err = unsubscribeDb(alice.Id, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = subscribeDb(alice.Id, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update_feed(db, feed)
+	if hasArticle(t, alice.Id, first) {
+		t.Error("subscribing again gave back the deleted article")
+	}
+}
+
+func TestPollOnlyGivesNewArticles(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	feed := testFeed(t, alice.Id)
+	const first = "https://blog.example.org/first"
+
+	update_feed(db, feed)
+	// removed without being recorded as deleted, like before deletions were
+	_, err := db.Exec("DELETE FROM user_articles WHERE user_id=? AND article=?", alice.Id, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update_feed(db, feed)
+	if hasArticle(t, alice.Id, first) {
+		t.Error("polling the feed again gave back an article that was already there")
+	}
+
+	// someone subscribing later still gets what the feed already has
+	bob, _ := testUser(t, "bob")
+	err = subscribeDb(bob.Id, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasArticle(t, bob.Id, first) {
+		t.Error("a new subscriber didn't get the feed's articles")
+	}
+}
+
+// Adds a tagged bookmark, which the default archive rule archives
+func testTaggedArticle(t *testing.T, userId int64, article string) {
+	t.Helper()
+	_, err := addArticleDb(Article{Url: article, Title: article, Date: "2026-01-01T12:00:00Z", Comments: []Comments{}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = addUserArticleDb(userId, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTagDb(userId, article, "reading")
+}
+
+func jobCount(t *testing.T, article string) int {
+	t.Helper()
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM jobs WHERE article=?", article).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSkippedArticlesAreNotQueuedAgain(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK"))
+	}))
+	t.Cleanup(server.Close)
+	article := server.URL + "/download.zip"
+	testTaggedArticle(t, alice.Id, article)
+
+	archive_pages(db)
+	ran, err := run_next_job(db)
+	if err != nil || !ran {
+		t.Fatalf("job didn't run: %v", err)
+	}
+	health := linkHealthDb(article)
+	if health.Status != "skipped" || !strings.Contains(health.LastError, "application/zip") {
+		t.Errorf("got link status %q with error %q", health.Status, health.LastError)
+	}
+
+	archive_pages(db)
+	archive_pages(db)
+	if count := jobCount(t, article); count != 1 {
+		t.Errorf("got %d jobs after queueing the skipped article again", count)
+	}
+	pending, err := existsDb("SELECT count(*) > 0 FROM jobs WHERE article=? AND status='pending'", article)
+	if err != nil || pending {
+		t.Errorf("the skipped article was queued again: %v", err)
+	}
+
+	// archiving it by hand reuses the failed job
+	id, err := queue_job(db, "archive", article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobDb(alice.Id, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "pending" || jobCount(t, article) != 1 {
+		t.Errorf("got job %+v and %d jobs after archiving by hand", job, jobCount(t, article))
+	}
+}
+
+func TestRobotsSkippedUntilRobotsChange(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	robots := "User-agent: *\nDisallow: /\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte(robots))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Page</title></head><body><p>Some words.</p></body></html>"))
+	}))
+	t.Cleanup(server.Close)
+	article := server.URL + "/page"
+	testTaggedArticle(t, alice.Id, article)
+
+	archive_pages(db)
+	ran, err := run_next_job(db)
+	if err != nil || !ran {
+		t.Fatalf("job didn't run: %v", err)
+	}
+	if health := linkHealthDb(article); health.Status != "skipped" {
+		t.Fatalf("got link status %q", health.Status)
+	}
+
+	// the same robots.txt, even fetched again, doesn't let it be queued
+	robotsCacheLock.Lock()
+	delete(robotsCache, server.URL)
+	robotsCacheLock.Unlock()
+	archive_pages(db)
+	if ran, err := run_next_job(db); err != nil || ran {
+		t.Fatalf("the page was queued again with the same robots.txt: %v", err)
+	}
+
+	robots = "User-agent: *\nDisallow: /private\n"
+	robotsCacheLock.Lock()
+	delete(robotsCache, server.URL)
+	robotsCacheLock.Unlock()
+	archive_pages(db)
+	ran, err = run_next_job(db)
+	if err != nil || !ran {
+		t.Fatalf("the page wasn't queued again after robots.txt changed: %v", err)
+	}
+	if archived, _ := archivedCopyDb(article); archived == nil {
+		t.Error("the page wasn't archived once robots.txt allowed it")
+	}
+	if health := linkHealthDb(article); health.Status != "ok" {
+		t.Errorf("got link status %q after archiving", health.Status)
+	}
+}
+
+func TestCheckLinksFollowsBackoff(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	now := time.Now()
+	links := []struct {
+		path        string
+		status      string
+		lastChecked time.Time
+		nextFetch   any
+		checked     bool
+	}{
+		{"/error-due", "error", now.Add(-time.Hour), now.Add(-time.Minute), true},
+		{"/error-backing-off", "error", now.Add(-8 * 24 * time.Hour), now.Add(10 * 24 * time.Hour), false},
+		{"/ok-recent", "ok", now.Add(-24 * time.Hour), nil, false},
+		{"/ok-old", "ok", now.Add(-8 * 24 * time.Hour), nil, true},
+	}
+	for _, link := range links {
+		article := server.URL + link.path
+		testTaggedArticle(t, alice.Id, article)
+		_, err := db.Exec("UPDATE articles SET archive='archived', link_status=?, last_checked=?, next_fetch=? WHERE url=?",
+			link.status, link.lastChecked, link.nextFetch, article)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check_links(db)
+	for _, link := range links {
+		checked, err := existsDb("SELECT last_checked > ? FROM articles WHERE url=?", now, server.URL+link.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checked != link.checked {
+			t.Errorf("%s was checked %v, expected %v", link.path, checked, link.checked)
+		}
+	}
+}
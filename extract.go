@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// The readable parts of an archived page
+type Extracted struct {
+	Title       string
+	Description string
+	Byline      string
+	// The zero time if the page doesn't say when it was published
+	Published time.Time
+	// Absolute URL of the image representing the page, empty if there isn't one
+	Image string
+	// The main content of the page as markdown
+	Markdown string
+}
+
+// Elements that are never part of the content
+const junkSelector = "script, style, noscript, iframe, form, nav, header, footer, aside, button, input, select, textarea, svg, canvas, template, dialog, [hidden], [aria-hidden=true], [role=navigation], [role=banner], [role=contentinfo], [role=complementary], [role=dialog]"
+
+// class and id names of elements that are probably not content, based on the ones used by readability.js
+var unlikelyRegex = regexp.MustCompile(`(?i)-ad-|^ad-|banner|breadcrumb|combx|comment|community|consent|cookie|cover-wrap|disqus|extra|gdpr|menu|modal|newsletter|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tweet|twitter|widget`)
+
+// class and id names that make an element less likely to be removed or more likely to be the content
+var likelyRegex = regexp.MustCompile(`(?i)and|article|body|column|content|main|page|post|shadow|story|text`)
+
+var positiveRegex = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|text|blog|story`)
+
+var negativeRegex = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|widget`)
+
+var bylineRegex = regexp.MustCompile(`(?i)byline|author|dateline|writtenby|p-author`)
+
+// Finds the main content and metadata of a page, pageUrl is used to resolve relative links
+func extractArticle(body io.Reader, pageUrl string) (Extracted, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return Extracted{}, err
+	}
+	base, err := documentBase(doc, pageUrl)
+	if err != nil {
+		return Extracted{}, err
+	}
+
+	// metadata has to be read before scripts are removed, since it can be in JSON-LD
+	extracted := extractMetadata(doc, base)
+
+	removeJunk(doc)
+	resolveUrls(doc, base)
+
+	content := findContent(doc)
+	// the title is shown separately so it shouldn't be repeated at the start of the content
+	content.Find("h1").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return strings.TrimSpace(s.Text()) == extracted.Title
+	}).Remove()
+
+	contentHtml, err := goquery.OuterHtml(content)
+	if err != nil {
+		return extracted, err
+	}
+	extracted.Markdown, err = htmltomarkdown.ConvertString(contentHtml)
+	if err != nil {
+		return extracted, err
+	}
+
+	return extracted, nil
+}
+
+// Finds the metadata of a page without its content, like for bookmarks. The page is decoded with the charset from
+// contentType, or the one the page declares if contentType doesn't have one.
+func extractPageMetadata(body []byte, contentType string, pageUrl string) (Extracted, error) {
+	decoded, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return Extracted{}, err
+	}
+	doc, err := goquery.NewDocumentFromReader(decoded)
+	if err != nil {
+		return Extracted{}, err
+	}
+	base, err := documentBase(doc, pageUrl)
+	if err != nil {
+		return Extracted{}, err
+	}
+	return extractMetadata(doc, base), nil
+}
+
+// The URL relative links in a page are relative to, which is where it is from unless it has a <base>
+func documentBase(doc *goquery.Document, pageUrl string) (*url.URL, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if baseHref, err := base.Parse(href); err == nil {
+			base = baseHref
+		}
+	}
+	return base, nil
+}
+
+// Reads the title, description, author, publish date and image of a page from its OpenGraph, Twitter and JSON-LD
+// metadata, falling back to what is in the page itself
+func extractMetadata(doc *goquery.Document, base *url.URL) Extracted {
+	var extracted Extracted
+	jsonLd := extractJsonLd(doc)
+	// unlike the rest of the page, entities in JSON-LD aren't decoded by the parser but some sites use them anyway
+	jsonLd.Headline = html.UnescapeString(jsonLd.Headline)
+	jsonLd.Description = html.UnescapeString(jsonLd.Description)
+
+	extracted.Title = firstNonEmpty(
+		metaContent(doc, "og:title"),
+		metaContent(doc, "twitter:title"),
+		jsonLd.Headline,
+		strings.TrimSpace(doc.Find("title").First().Text()),
+		strings.TrimSpace(doc.Find("h1").First().Text()),
+	)
+	extracted.Description = firstNonEmpty(
+		metaContent(doc, "og:description"),
+		metaContent(doc, "twitter:description"),
+		jsonLd.Description,
+		metaContent(doc, "description"),
+	)
+	extracted.Byline = firstNonEmpty(
+		jsonLd.authorName(),
+		metaContent(doc, "author"),
+		metaContent(doc, "article:author"),
+		metaContent(doc, "twitter:creator"),
+		strings.TrimSpace(doc.Find("[rel=author], [itemprop=author]").First().Text()),
+		strings.TrimSpace(doc.Find("[class*=byline], [class*=author]").First().Text()),
+	)
+	extracted.Published = parseDate(firstNonEmpty(
+		metaContent(doc, "article:published_time"),
+		jsonLd.DatePublished,
+		metaContent(doc, "date"),
+		metaContent(doc, "dc.date"),
+		doc.Find("time[datetime]").First().AttrOr("datetime", ""),
+	))
+	extracted.Image = resolveUrl(base, firstNonEmpty(
+		metaContent(doc, "og:image"),
+		metaContent(doc, "twitter:image"),
+		jsonLd.imageUrl(),
+	))
+	return extracted
+}
+
+// Removes elements that are never content, like navigation, scripts and cookie banners
+func removeJunk(doc *goquery.Document) {
+	doc.Find(junkSelector).Remove()
+	doc.Find("[class], [id]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		if s.Is("html, body, article, main") {
+			return false
+		}
+		names := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		return unlikelyRegex.MatchString(names) && !likelyRegex.MatchString(names)
+	}).Remove()
+	doc.Find("[class], [id]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		names := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		return bylineRegex.MatchString(names) && len(s.Text()) < 100
+	}).Remove()
+}
+
+// Makes links and images absolute so they still work outside of the page
+func resolveUrls(doc *goquery.Document, base *url.URL) {
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		s.SetAttr("href", resolveUrl(base, s.AttrOr("href", "")))
+	})
+	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
+		// lazy loaded images keep the real source in a data attribute
+		src := firstNonEmpty(s.AttrOr("data-src", ""), s.AttrOr("data-original", ""), s.AttrOr("src", ""))
+		if src == "" {
+			s.Remove()
+			return
+		}
+		s.SetAttr("src", resolveUrl(base, src))
+		s.RemoveAttr("srcset")
+	})
+}
+
+// Finds the element that is most likely to be the main content, using the paragraph scoring from readability.js
+func findContent(doc *goquery.Document) *goquery.Selection {
+	// a single article element is almost always the content
+	if articles := doc.Find("article"); articles.Length() == 1 && textLength(articles) > 500 {
+		return articles
+	}
+
+	scores := map[*html.Node]float64{}
+	candidates := []*goquery.Selection{}
+	doc.Find("p, pre, td, blockquote, li").Each(func(_ int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if len(text) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+
+		parent := s.Parent()
+		for level := 0; level < 3 && parent.Length() > 0 && !parent.Is("body, html"); level++ {
+			node := parent.Get(0)
+			if _, ok := scores[node]; !ok {
+				scores[node] = classWeight(parent) + tagWeight(parent)
+				candidates = append(candidates, parent)
+			}
+			// the parent gets the full score, the grandparent half and the great-grandparent a sixth
+			divider := float64(level + 1)
+			if level == 2 {
+				divider = 3 * 2
+			}
+			scores[node] += score / divider
+			parent = parent.Parent()
+		}
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, candidate := range candidates {
+		// mostly links is navigation, not content
+		score := scores[candidate.Get(0)] * (1 - linkDensity(candidate))
+		if best == nil || score > bestScore {
+			best = candidate
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		if main := doc.Find("main, [role=main]").First(); main.Length() > 0 {
+			return main
+		}
+		return doc.Find("body")
+	}
+	return best
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	weight := 0.0
+	for _, name := range []string{s.AttrOr("class", ""), s.AttrOr("id", "")} {
+		if name == "" {
+			continue
+		}
+		if negativeRegex.MatchString(name) {
+			weight -= 25
+		}
+		if positiveRegex.MatchString(name) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+func tagWeight(s *goquery.Selection) float64 {
+	switch goquery.NodeName(s) {
+	case "article", "main":
+		return 10
+	case "div":
+		return 5
+	case "pre", "td", "blockquote":
+		return 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		return -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		return -5
+	}
+	return 0
+}
+
+func textLength(s *goquery.Selection) int {
+	return len(strings.TrimSpace(s.Text()))
+}
+
+// The fraction of an element's text that is inside of links
+func linkDensity(s *goquery.Selection) float64 {
+	length := textLength(s)
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLength += textLength(a)
+	})
+	return float64(linkLength) / float64(length)
+}
+
+// Gets the content of a meta tag by either its name or property
+func metaContent(doc *goquery.Document, name string) string {
+	content := ""
+	doc.Find("meta[content]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if strings.EqualFold(s.AttrOr("property", ""), name) || strings.EqualFold(s.AttrOr("name", ""), name) {
+			content = strings.TrimSpace(s.AttrOr("content", ""))
+		}
+		return content == ""
+	})
+	return content
+}
+
+// The fields of a schema.org Article that we use
+type jsonLdArticle struct {
+	Type          any             `json:"@type"`
+	Headline      string          `json:"headline"`
+	Description   string          `json:"description"`
+	DatePublished string          `json:"datePublished"`
+	Author        any             `json:"author"`
+	Image         any             `json:"image"`
+	Graph         []jsonLdArticle `json:"@graph"`
+}
+
+// Finds the first schema.org article in the page's JSON-LD
+func extractJsonLd(doc *goquery.Document) jsonLdArticle {
+	var found jsonLdArticle
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		var objects []jsonLdArticle
+		text := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(text, "[") {
+			if json.Unmarshal([]byte(text), &objects) != nil {
+				return true
+			}
+		} else {
+			var object jsonLdArticle
+			if json.Unmarshal([]byte(text), &object) != nil {
+				return true
+			}
+			objects = append([]jsonLdArticle{object}, object.Graph...)
+		}
+		for _, object := range objects {
+			if object.Headline != "" || object.DatePublished != "" {
+				found = object
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// Authors can be a string, an object with a name, or a list of either
+func (article jsonLdArticle) authorName() string {
+	return jsonLdName(article.Author, "name")
+}
+
+// Images can be a string, an ImageObject with a url, or a list of either
+func (article jsonLdArticle) imageUrl() string {
+	return jsonLdName(article.Image, "url")
+}
+
+func jsonLdName(value any, key string) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]any:
+		name, _ := v[key].(string)
+		return strings.TrimSpace(name)
+	case []any:
+		var names []string
+		for _, item := range v {
+			if name := jsonLdName(item, key); name != "" {
+				names = append(names, name)
+			}
+		}
+		if key == "url" && len(names) > 0 {
+			return names[0]
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04:05Z0700", "2006-01-02 15:04:05", "2006-01-02", time.RFC1123Z, time.RFC1123}
+
+// Parses the date formats used in metadata, returns the zero time if it can't
+func parseDate(date string) time.Time {
+	for _, layout := range dateLayouts {
+		parsed, err := time.Parse(layout, date)
+		if err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+func resolveUrl(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return resolved.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
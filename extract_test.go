@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractArticle(t *testing.T) {
+	tests := []struct {
+		file      string
+		url       string
+		title     string
+		byline    string
+		published time.Time
+		image     string
+		// text that has to be in the content
+		content []string
+		// boilerplate that mustn't be
+		junk []string
+	}{
+		{
+			file:      "news-article.html",
+			url:       "https://news.example/2024/03/bike-lanes",
+			title:     "City council approves new bike lanes",
+			byline:    "Maria Lopez, Sam Chen",
+			published: time.Date(2024, 3, 12, 8, 30, 0, 0, time.UTC),
+			image:     "https://news.example/images/bike-lanes.jpg",
+			content:   []string{"protected bike lanes", "Construction is expected", "https://news.example/images/map.png"},
+			junk:      []string{"Advertisement", "Related stories", "Copyright", "Sports", "window.analytics", "By Maria Lopez"},
+		},
+		{
+			file:      "blog-post.html",
+			url:       "https://robin.example/posts/plain-html.html",
+			title:     "Why I switched my static site to plain HTML — Notes by Robin",
+			byline:    "Robin Park",
+			published: time.Date(2023, 11, 5, 0, 0, 0, 0, time.UTC),
+			image:     "https://robin.example/cover.png",
+			content:   []string{"hand written HTML file", "https://robin.example/posts/old-setup.html"},
+			junk:      []string{"Robin writes about the web", "Archive", "Share on Twitter", "Comments are closed"},
+		},
+		{
+			file:      "cookie-banner.html",
+			url:       "https://bake.example/guides/starter",
+			title:     "Sourdough starter guide",
+			published: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			image:     "https://bake.example/starter.jpg",
+			content:   []string{"wild yeast", "feed it once a week"},
+			junk:      []string{"cookies", "newsletter", "Recipes", "Flour Street"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			file, err := os.Open("testdata/" + test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			extracted, err := extractArticle(file, test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if extracted.Title != test.title {
+				t.Errorf("title = %q, want %q", extracted.Title, test.title)
+			}
+			if extracted.Byline != test.byline {
+				t.Errorf("byline = %q, want %q", extracted.Byline, test.byline)
+			}
+			if !extracted.Published.Equal(test.published) {
+				t.Errorf("published = %v, want %v", extracted.Published, test.published)
+			}
+			if extracted.Image != test.image {
+				t.Errorf("image = %q, want %q", extracted.Image, test.image)
+			}
+			for _, text := range test.content {
+				if !strings.Contains(extracted.Markdown, text) {
+					t.Errorf("content is missing %q:\n%s", text, extracted.Markdown)
+				}
+			}
+			for _, text := range test.junk {
+				if strings.Contains(extracted.Markdown, text) {
+					t.Errorf("content has boilerplate %q:\n%s", text, extracted.Markdown)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sent with every request so site owners can tell who is fetching their pages, set with NAARUM_USER_AGENT
+var userAgent = envOr("NAARUM_USER_AGENT", "NaarumRSSReader/1.0 (self-hosted feed reader and archiver)")
+
+// The names matched against the User-agent lines of robots.txt
+var robotsAgents = []string{"naarumrssreader", "naarum"}
+
+// Limits on how much is read from a response
+const maxFeedSize = 10 * 1024 * 1024
+const maxPageSize = 10 * 1024 * 1024
+const maxRobotsSize = 512 * 1024
+
+// The client used for every outgoing request. The timeout is set with NAARUM_FETCH_TIMEOUT (ie "45s"),
+// and requests go through NAARUM_PROXY if it is set, or the usual HTTP_PROXY and HTTPS_PROXY variables if it isn't.
+var httpClient = newHttpClient()
+
+func newHttpClient() *http.Client {
+	timeout := 30 * time.Second
+	if value := os.Getenv("NAARUM_FETCH_TIMEOUT"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid NAARUM_FETCH_TIMEOUT %q: %v", value, err))
+		}
+		timeout = parsed
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if value := os.Getenv("NAARUM_PROXY"); value != "" {
+		proxyUrl, err := url.Parse(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid NAARUM_PROXY %q: %v", value, err))
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		ForceAttemptHTTP2:     true,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: userAgentTransport{transport},
+	}
+}
+
+// Sets the User-Agent of every request that doesn't already have one
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
+
+var errTooLarge = errors.New("response is too large")
+
+// Reads a whole body, failing with errTooLarge instead of truncating it if it is larger than limit
+func readLimited(body io.Reader, limit int64) ([]byte, error) {
+	// read one byte past the limit to tell if the body was too large
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: it is over %d bytes", errTooLarge, limit)
+	}
+	return data, nil
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// How long a host's robots.txt is trusted before it is fetched again
+const robotsCacheDuration = 24 * time.Hour
+
+// Hosts whose robots.txt couldn't be fetched are tried again sooner
+const robotsErrorCacheDuration = time.Hour
+
+// The rules from a robots.txt that apply to us
+type robotsRules struct {
+	allow    []string
+	disallow []string
+	expires  time.Time
+	// a hash of the robots.txt, empty if there wasn't one
+	hash string
+}
+
+// robots.txt rules by scheme and host, ie "https://example.com"
+var robotsCache = map[string]robotsRules{}
+var robotsCacheLock sync.Mutex
+
+// Whether robots.txt lets us fetch a page, see https://www.rfc-editor.org/rfc/rfc9309.
+// Also returns the hash of the robots.txt that decided it, to tell when it changes.
+func robotsAllowed(pageUrl string) (bool, string) {
+	parsed, err := url.Parse(pageUrl)
+	if err != nil || parsed.Host == "" {
+		return true, ""
+	}
+	origin := parsed.Scheme + "://" + parsed.Host
+
+	robotsCacheLock.Lock()
+	rules, ok := robotsCache[origin]
+	robotsCacheLock.Unlock()
+	if !ok || time.Now().After(rules.expires) {
+		rules = fetchRobots(origin)
+		robotsCacheLock.Lock()
+		robotsCache[origin] = rules
+		robotsCacheLock.Unlock()
+	}
+
+	path := parsed.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if parsed.RawQuery != "" {
+		path += "?" + parsed.RawQuery
+	}
+	return rules.allows(path), rules.hash
+}
+
+func fetchRobots(origin string) robotsRules {
+	resp, err := httpClient.Get(origin + "/robots.txt")
+	if err != nil {
+		slog.Error("unable to get robots.txt", "host", origin, "error", err)
+		return robotsRules{expires: time.Now().Add(robotsErrorCacheDuration)}
+	}
+	defer resp.Body.Close()
+	// a missing robots.txt allows everything, server errors are treated the same so a broken site can still be archived
+	if resp.StatusCode != http.StatusOK {
+		expires := time.Now().Add(robotsCacheDuration)
+		if resp.StatusCode >= 500 {
+			expires = time.Now().Add(robotsErrorCacheDuration)
+		}
+		return robotsRules{expires: expires}
+	}
+
+	body, err := readLimited(resp.Body, maxRobotsSize)
+	if err != nil {
+		slog.Error("unable to read robots.txt", "host", origin, "error", err)
+		return robotsRules{expires: time.Now().Add(robotsErrorCacheDuration)}
+	}
+	rules := parseRobots(string(body))
+	rules.expires = time.Now().Add(robotsCacheDuration)
+	sum := sha256.Sum256(body)
+	rules.hash = hex.EncodeToString(sum[:])
+	return rules
+}
+
+// Gets the rules of the group for our user agent, or of the * group if there isn't one
+func parseRobots(robots string) robotsRules {
+	var ours, everyone robotsRules
+	foundOurs := false
+	// the agents of the current group, a group can have several User-agent lines
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(strings.NewReader(robots))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			for _, agent := range agents {
+				var rules *robotsRules
+				if agent == "*" {
+					rules = &everyone
+				} else if slices.Contains(robotsAgents, agent) {
+					rules = &ours
+					foundOurs = true
+				} else {
+					continue
+				}
+				// an empty disallow means nothing is disallowed, but the group is still ours
+				if value == "" {
+					continue
+				}
+				if name == "allow" {
+					rules.allow = append(rules.allow, value)
+				} else {
+					rules.disallow = append(rules.disallow, value)
+				}
+			}
+		}
+	}
+	if foundOurs {
+		return ours
+	}
+	return everyone
+}
+
+// The most specific (longest) matching rule wins, and allow wins ties
+func (rules robotsRules) allows(path string) bool {
+	longestAllow, longestDisallow := -1, -1
+	for _, pattern := range rules.allow {
+		if robotsMatch(pattern, path) {
+			longestAllow = max(longestAllow, len(pattern))
+		}
+	}
+	for _, pattern := range rules.disallow {
+		if robotsMatch(pattern, path) {
+			longestDisallow = max(longestDisallow, len(pattern))
+		}
+	}
+	return longestAllow >= longestDisallow
+}
+
+// Matches a robots.txt path pattern, where * matches anything and a trailing $ anchors the end
+func robotsMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		// the last part has to be at the very end of an anchored pattern
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		index := strings.Index(rest, part)
+		if index == -1 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return !anchored || rest == ""
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestParseRobotsEmptyDisallowForUs(t *testing.T) {
+	rules := parseRobots("User-agent: naarum\nDisallow:\n\nUser-agent: *\nDisallow: /\n")
+	if !rules.allows("/post") {
+		t.Errorf("an empty Disallow in our group should allow everything, got %+v", rules)
+	}
+}
+
+func TestParseRobotsFallsBackToEveryone(t *testing.T) {
+	rules := parseRobots("User-agent: googlebot\nDisallow:\n\nUser-agent: *\nDisallow: /private\n")
+	if rules.allows("/private/page") {
+		t.Errorf("the * group should apply when there isn't one for us, got %+v", rules)
+	}
+	if !rules.allows("/post") {
+		t.Errorf("only /private should be disallowed, got %+v", rules)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Fever API, see https://feedafever.com/api. Everything goes through /fever/?api with parameters naming what to
+// get or change. Groups are the folders of feeds, feeds without one are in feverDefaultGroup, and savedTag is saved.
+
+// Feeds without a folder are put in this group, since some clients hide feeds that aren't in one
+const feverDefaultGroup = "Feeds"
+
+// Fever never returns more than 50 items at a time
+const feverMaxItems = 50
+
+type feverFeed struct {
+	Id      int64
+	Url     string
+	Title   string
+	Updated time.Time
+	// empty if the feed isn't in a folder
+	Folder string
+}
+
+type feverItem struct {
+	Id            int64  `json:"id"`
+	FeedId        int64  `json:"feed_id"`
+	Title         string `json:"title"`
+	Author        string `json:"author"`
+	Html          string `json:"html"`
+	Url           string `json:"url"`
+	IsSaved       int    `json:"is_saved"`
+	IsRead        int    `json:"is_read"`
+	CreatedOnTime int64  `json:"created_on_time"`
+}
+
+// Groups don't have ids, so they are derived from the name to stay the same between requests
+func feverGroupId(name string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(name)) & 0x7fffffff)
+}
+
+func (f feverFeed) groups() []string {
+	if f.Folder == "" {
+		return []string{feverDefaultGroup}
+	}
+	return []string{f.Folder}
+}
+
+func joinIds(ids []int64) string {
+	converted := make([]string, len(ids))
+	for i, id := range ids {
+		converted[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(converted, ",")
+}
+
+func feverHandler(w http.ResponseWriter, r *http.Request) {
+	// the api_key is in the body, everything else can be in either the query or the body
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if _, ok := r.Form["api"]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing api parameter"))
+		return
+	}
+
+	// the api_key is the MD5 of "name:password", see feverKey
+	response := map[string]any{"api_version": 3, "auth": 0}
+	user, err := userByFeverKeyDb(strings.ToLower(r.PostForm.Get("api_key")))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJson(w, http.StatusOK, response)
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to check Fever api_key", err)
+		return
+	}
+	response["auth"] = 1
+
+	err = feverRespond(withUser(r, user), response)
+	if err != nil {
+		writeServiceError(w, r, "failed to answer Fever request", err)
+		return
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// Fills in the response with everything the request asked for, after making the changes it asked for
+func feverRespond(r *http.Request, response map[string]any) error {
+	userId := currentUser(r).Id
+	if r.PostForm.Get("mark") != "" {
+		err := feverMark(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	total, refreshed, err := feverStatusDb(userId)
+	if err != nil {
+		return err
+	}
+	response["last_refreshed_on_time"] = max(refreshed.Unix(), 0)
+
+	_, wantGroups := r.Form["groups"]
+	_, wantFeeds := r.Form["feeds"]
+	if wantGroups || wantFeeds {
+		feeds, err := feverFeedsDb(userId)
+		if err != nil {
+			return err
+		}
+		feverAddFeeds(response, feeds, wantGroups, wantFeeds)
+	}
+
+	if _, ok := r.Form["favicons"]; ok {
+		response["favicons"] = []any{}
+	}
+	// hot links are Fever's ranking of links shared by several feeds, which isn't tracked
+	if _, ok := r.Form["links"]; ok {
+		response["links"] = []any{}
+	}
+
+	if _, ok := r.Form["items"]; ok {
+		items, err := feverItems(r)
+		if err != nil {
+			return err
+		}
+		response["items"] = items
+		response["total_items"] = total
+	}
+
+	if _, ok := r.Form["unread_item_ids"]; ok {
+		ids, err := itemIdsDb(userId, "read_at IS NULL")
+		if err != nil {
+			return err
+		}
+		response["unread_item_ids"] = joinIds(ids)
+	}
+	if _, ok := r.Form["saved_item_ids"]; ok {
+		ids, err := itemIdsDb(userId, "list_contains(tags, ?)", savedTag)
+		if err != nil {
+			return err
+		}
+		response["saved_item_ids"] = joinIds(ids)
+	}
+	return nil
+}
+
+func feverAddFeeds(response map[string]any, feeds []feverFeed, wantGroups bool, wantFeeds bool) {
+	type group struct {
+		Id    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+	type feedsGroup struct {
+		GroupId int64  `json:"group_id"`
+		FeedIds string `json:"feed_ids"`
+	}
+	type feed struct {
+		Id                int64  `json:"id"`
+		FaviconId         int64  `json:"favicon_id"`
+		Title             string `json:"title"`
+		Url               string `json:"url"`
+		SiteUrl           string `json:"site_url"`
+		IsSpark           int    `json:"is_spark"`
+		LastUpdatedOnTime int64  `json:"last_updated_on_time"`
+	}
+
+	var names []string
+	members := map[string][]int64{}
+	converted := []feed{}
+	for _, f := range feeds {
+		for _, name := range f.groups() {
+			if _, ok := members[name]; !ok {
+				names = append(names, name)
+			}
+			members[name] = append(members[name], f.Id)
+		}
+		title := f.Title
+		if title == "" {
+			title = f.Url
+		}
+		siteUrl := f.Url
+		if parsed, err := url.Parse(f.Url); err == nil {
+			siteUrl = parsed.Scheme + "://" + parsed.Host
+		}
+		converted = append(converted, feed{
+			Id:                f.Id,
+			Title:             title,
+			Url:               f.Url,
+			SiteUrl:           siteUrl,
+			LastUpdatedOnTime: max(f.Updated.Unix(), 0),
+		})
+	}
+	slices.Sort(names)
+
+	groups := []group{}
+	feedsGroups := []feedsGroup{}
+	for _, name := range names {
+		groups = append(groups, group{feverGroupId(name), name})
+		feedsGroups = append(feedsGroups, feedsGroup{feverGroupId(name), joinIds(members[name])})
+	}
+	if wantGroups {
+		response["groups"] = groups
+	}
+	if wantFeeds {
+		response["feeds"] = converted
+	}
+	response["feeds_groups"] = feedsGroups
+}
+
+// Gets 50 items after since_id, before max_id, or with_ids
+func feverItems(r *http.Request) ([]feverItem, error) {
+	userId := currentUser(r).Id
+	if value := r.Form.Get("with_ids"); value != "" {
+		ids, err := feverIds(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > feverMaxItems {
+			ids = ids[:feverMaxItems]
+		}
+		args := make([]any, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		return feverItemsDb(userId, "item_id IN ("+placeholders(len(ids))+")", args, false, feverMaxItems)
+	}
+	if value := r.Form.Get("max_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid max_id %q", errInvalid, value)
+		}
+		return feverItemsDb(userId, "item_id < ?", []any{id}, true, feverMaxItems)
+	}
+	sinceId := int64(0)
+	if value := r.Form.Get("since_id"); value != "" {
+		var err error
+		sinceId, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid since_id %q", errInvalid, value)
+		}
+	}
+	return feverItemsDb(userId, "item_id > ?", []any{sinceId}, false, feverMaxItems)
+}
+
+func feverIds(value string) ([]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid id %q", errInvalid, part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Marks an item read, unread, saved or unsaved, or a feed or group read up to a time (before)
+func feverMark(r *http.Request) error {
+	userId := currentUser(r).Id
+	mark, as := r.PostForm.Get("mark"), r.PostForm.Get("as")
+	id, err := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid id %q", errInvalid, r.PostForm.Get("id"))
+	}
+
+	if mark == "item" {
+		urls, err := itemUrlsDb(userId, []int64{id})
+		if err != nil {
+			return err
+		}
+		action, tag := "", ""
+		switch as {
+		case "read":
+			action = "mark_read"
+		case "unread":
+			action = "mark_unread"
+		case "saved":
+			action, tag = "add_tag", savedTag
+		case "unsaved":
+			action, tag = "remove_tag", savedTag
+		default:
+			return fmt.Errorf("%w: can't mark an item as %q", errInvalid, as)
+		}
+		_, err = bulkDb(userId, urls, action, tag, "")
+		if err != nil {
+			return err
+		}
+		if action == "add_tag" {
+			for _, article := range urls {
+				apply_archive_policy(db, article)
+			}
+		}
+		// clients expect the new ids back so they don't have to ask again
+		if action == "mark_read" || action == "mark_unread" {
+			r.Form["unread_item_ids"] = nil
+		} else {
+			r.Form["saved_item_ids"] = nil
+		}
+		return nil
+	}
+
+	if as != "read" {
+		return fmt.Errorf("%w: can't mark a %s as %q", errInvalid, mark, as)
+	}
+	before := time.Now()
+	if value := r.PostForm.Get("before"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid before %q", errInvalid, value)
+		}
+		before = time.Unix(seconds, 0)
+	}
+
+	var feedUrls []string
+	switch mark {
+	case "feed", "group":
+		feeds, err := feverFeedsDb(userId)
+		if err != nil {
+			return err
+		}
+		for _, f := range feeds {
+			if mark == "feed" && f.Id == id {
+				feedUrls = append(feedUrls, f.Url)
+			}
+			// group 0 is the Kindling super group with every feed
+			if mark == "group" && (id == 0 || slices.ContainsFunc(f.groups(), func(name string) bool { return feverGroupId(name) == id })) {
+				feedUrls = append(feedUrls, f.Url)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: can't mark a %q", errInvalid, mark)
+	}
+	if len(feedUrls) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(feedUrls))
+	for i, feedUrl := range feedUrls {
+		args[i] = feedUrl
+	}
+	_, err = markStreamReadDb(userId, "feed IN ("+placeholders(len(feedUrls))+")", args, before.UTC())
+	if err != nil {
+		return err
+	}
+	r.Form["unread_item_ids"] = nil
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func feverGroups(t *testing.T, name string) map[string]string {
+	t.Helper()
+	form := url.Values{"api_key": {feverKey(name, "correct horse battery")}}
+	r := httptest.NewRequest(http.MethodPost, "/fever/?api&groups", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	feverHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Auth   int
+		Groups []struct {
+			Id    int64
+			Title string
+		}
+		FeedsGroups []struct {
+			GroupId int64  `json:"group_id"`
+			FeedIds string `json:"feed_ids"`
+		} `json:"feeds_groups"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil || response.Auth != 1 {
+		t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+	}
+	// the feed ids in each group by the group's title
+	groups := map[string]string{}
+	for _, group := range response.Groups {
+		for _, feedsGroup := range response.FeedsGroups {
+			if feedsGroup.GroupId == group.Id {
+				groups[group.Title] = feedsGroup.FeedIds
+			}
+		}
+	}
+	return groups
+}
+
+func feverFeedId(t *testing.T, feed string) string {
+	t.Helper()
+	var id int64
+	err := db.QueryRow("SELECT feed_id FROM feeds WHERE url=?", feed).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+func TestFeverGroupsFromFolders(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	bob, _ := testUser(t, "bob")
+	seedGreader(t, alice.Id, bob.Id)
+	blog, news := feverFeedId(t, testBlogFeed), feverFeedId(t, testNewsFeed)
+
+	groups := feverGroups(t, "alice")
+	if len(groups) != 1 || groups[feverDefaultGroup] != blog+","+news {
+		t.Errorf("got groups %v before there were folders", groups)
+	}
+
+	err := setFeedFolder(alice.Id, testBlogFeed, "Tech")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups = feverGroups(t, "alice")
+	if len(groups) != 2 || groups["Tech"] != blog || groups[feverDefaultGroup] != news {
+		t.Errorf("got groups %v after putting the blog in a folder", groups)
+	}
+	// folders are per user
+	groups = feverGroups(t, "bob")
+	if len(groups) != 1 || groups[feverDefaultGroup] != blog+","+news {
+		t.Errorf("got groups %v for another user", groups)
+	}
+
+	err = setFeedFolder(alice.Id, testBlogFeed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups = feverGroups(t, "alice"); len(groups) != 1 {
+		t.Errorf("got groups %v after taking the blog out of its folder", groups)
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Google Reader API used by mobile apps like Reeder, FeedMe and NetNewsWire. There is no spec, this follows
+// what the clients send and what FreshRSS and Miniflux answer. Tags are labels, and savedTag is starred.
+
+// Streams are articles selected by a state, a label or a feed
+const (
+	greaderReadingList = "user/-/state/com.google/reading-list"
+	greaderRead        = "user/-/state/com.google/read"
+	greaderKeptUnread  = "user/-/state/com.google/kept-unread"
+	greaderStarred     = "user/-/state/com.google/starred"
+	greaderLabelPrefix = "user/-/label/"
+	greaderFeedPrefix  = "feed/"
+)
+
+const greaderItemPrefix = "tag:google.com,2005:reader/item/"
+
+// Clients ask for a lot of ids at once to sync, but only a few items with their contents
+const greaderDefaultCount = 20
+const greaderMaxIds = 10000
+const greaderMaxItems = 1000
+
+type greaderItemRef struct {
+	Id   int64
+	Date time.Time
+}
+
+type greaderItem struct {
+	Id    int64
+	Url   string
+	Title string
+	Date  time.Time
+	Tags  []string
+	Read  bool
+	// the feed URL, empty for bookmarks
+	Feed      string
+	FeedTitle string
+	// the archived HTML, empty if there isn't one
+	Content string
+}
+
+type greaderUnreadCount struct {
+	// only one of Feed and Tag is set, neither is for the total
+	Feed   string
+	Tag    string
+	Count  int
+	Newest time.Time
+}
+
+func greaderAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "GoogleLogin auth=")
+		user, err := sessionUser(token, "greader")
+		if errors.Is(err, errBadLogin) {
+			w.Header().Set("Google-Bad-Token", "true")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		if err != nil {
+			writeServiceError(w, r, "failed to check Google Reader token", err)
+			return
+		}
+		// clients send parameters in both the query and the body
+		err = r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		handler(w, withUser(r, user))
+	}
+}
+
+// Logs in with a local account, the token is a session that lasts a year since clients don't log in again by themselves
+func greaderClientLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	user, err := authenticate(r.Form.Get("Email"), r.Form.Get("Passwd"))
+	if errors.Is(err, errBadLogin) {
+		slog.InfoContext(r.Context(), "failed Google Reader login", "user", r.Form.Get("Email"))
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error=BadAuthentication\n"))
+		return
+	}
+	var token string
+	if err == nil {
+		token, err = startSession(user, "greader", greaderSessionDuration)
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to log in Google Reader client", err)
+		return
+	}
+	if r.Form.Get("output") == "json" {
+		writeJson(w, http.StatusOK, map[string]string{"SID": token, "LSID": token, "Auth": token})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+}
+
+// The token POST requests are supposed to send back, it isn't checked since the Authorization header already is
+func greaderEditToken(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "GoogleLogin auth=")
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(token))
+}
+
+func greaderUserInfo(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	writeJson(w, http.StatusOK, map[string]string{
+		"userId":        strconv.FormatInt(user.Id, 10),
+		"userName":      user.Name,
+		"userProfileId": strconv.FormatInt(user.Id, 10),
+		"userEmail":     "",
+	})
+}
+
+func greaderSubscriptions(w http.ResponseWriter, r *http.Request) {
+	type category struct {
+		Id    string `json:"id"`
+		Label string `json:"label"`
+	}
+	type subscription struct {
+		Id         string     `json:"id"`
+		Title      string     `json:"title"`
+		Categories []category `json:"categories"`
+		Url        string     `json:"url"`
+		HtmlUrl    string     `json:"htmlUrl"`
+		IconUrl    string     `json:"iconUrl"`
+	}
+	subscriptions := []subscription{}
+	for _, f := range feedsDb(currentUser(r).Id) {
+		title := f.Title
+		if title == "" {
+			title = f.FeedUrl
+		}
+		// folders are the categories
+		categories := []category{}
+		if f.Folder != "" {
+			categories = append(categories, category{greaderLabelPrefix + f.Folder, f.Folder})
+		}
+		subscriptions = append(subscriptions, subscription{
+			Id:         greaderFeedPrefix + f.FeedUrl,
+			Title:      title,
+			Categories: categories,
+			Url:        f.FeedUrl,
+			HtmlUrl:    f.SiteUrl,
+		})
+	}
+	writeJson(w, http.StatusOK, map[string]any{"subscriptions": subscriptions})
+}
+
+func greaderTags(w http.ResponseWriter, r *http.Request) {
+	type tag struct {
+		Id   string `json:"id"`
+		Type string `json:"type,omitempty"`
+	}
+	tags := []tag{{Id: greaderStarred}}
+	for _, t := range tagsDb(currentUser(r).Id) {
+		tags = append(tags, tag{Id: greaderLabelPrefix + t.Name, Type: "tag"})
+	}
+	for _, f := range feedsDb(currentUser(r).Id) {
+		folder := tag{Id: greaderLabelPrefix + f.Folder, Type: "folder"}
+		if f.Folder != "" && !slices.ContainsFunc(tags, func(t tag) bool { return t.Id == folder.Id }) {
+			tags = append(tags, folder)
+		}
+	}
+	writeJson(w, http.StatusOK, map[string]any{"tags": tags})
+}
+
+func greaderUnreadCounts(w http.ResponseWriter, r *http.Request) {
+	counts, err := greaderUnreadCountsDb(currentUser(r).Id)
+	if err != nil {
+		writeServiceError(w, r, "failed to count unread articles", err)
+		return
+	}
+	type unreadCount struct {
+		Id                      string `json:"id"`
+		Count                   int    `json:"count"`
+		NewestItemTimestampUsec string `json:"newestItemTimestampUsec"`
+	}
+	unreadCounts := []unreadCount{}
+	for _, count := range counts {
+		id := greaderReadingList
+		if count.Feed != "" {
+			id = greaderFeedPrefix + count.Feed
+		} else if count.Tag != "" {
+			id = greaderLabelPrefix + count.Tag
+		}
+		newest := "0"
+		if !count.Newest.IsZero() {
+			newest = strconv.FormatInt(count.Newest.UnixMicro(), 10)
+		}
+		unreadCounts = append(unreadCounts, unreadCount{id, count.Count, newest})
+		if count.Tag == savedTag {
+			unreadCounts = append(unreadCounts, unreadCount{greaderStarred, count.Count, newest})
+		}
+	}
+	writeJson(w, http.StatusOK, map[string]any{"max": greaderMaxItems, "unreadcounts": unreadCounts})
+}
+
+// Changes subscriptions (s), only moving feeds between folders with ac=edit is supported. Clients move a feed by
+// removing (r) the label of its folder and adding (a) the label of the new one.
+func greaderEditSubscription(w http.ResponseWriter, r *http.Request) {
+	if action := r.Form.Get("ac"); action != "edit" {
+		writeServiceError(w, r, "unsupported subscription change", fmt.Errorf("%w: can't %s subscriptions", errInvalid, action))
+		return
+	}
+	userId := currentUser(r).Id
+	for _, stream := range r.Form["s"] {
+		feedUrl, found := strings.CutPrefix(stream, greaderFeedPrefix)
+		if !found {
+			writeServiceError(w, r, "invalid subscription", fmt.Errorf("%w: %s isn't a feed", errInvalid, stream))
+			return
+		}
+		var current string
+		for _, f := range feedsDb(userId) {
+			if f.FeedUrl == feedUrl {
+				current = f.Folder
+			}
+		}
+
+		folder := current
+		for _, label := range r.Form["r"] {
+			if greaderCanonicalStream(label) == greaderLabelPrefix+current {
+				folder = ""
+			}
+		}
+		for _, label := range r.Form["a"] {
+			name, found := strings.CutPrefix(greaderCanonicalStream(label), greaderLabelPrefix)
+			if !found {
+				writeServiceError(w, r, "invalid folder", fmt.Errorf("%w: %s isn't a label", errInvalid, label))
+				return
+			}
+			folder = name
+		}
+		err := setFeedFolder(userId, feedUrl, folder)
+		if err != nil {
+			writeServiceError(w, r, "failed to move feed to folder", err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+// Replaces the user id in stream ids with "-", some clients use the id from user-info instead
+func greaderCanonicalStream(stream string) string {
+	rest, found := strings.CutPrefix(stream, "user/")
+	if !found {
+		return stream
+	}
+	_, rest, _ = strings.Cut(rest, "/")
+	return "user/-/" + rest
+}
+
+// Gets the SQL condition selecting the articles in a stream
+func greaderStreamCondition(stream string) (string, []any, error) {
+	stream = greaderCanonicalStream(stream)
+	switch {
+	case stream == greaderReadingList:
+		return "true", nil, nil
+	case stream == greaderRead:
+		return "read_at IS NOT NULL", nil, nil
+	case stream == greaderKeptUnread:
+		return "read_at IS NULL", nil, nil
+	case stream == greaderStarred:
+		return "list_contains(tags, ?)", []any{savedTag}, nil
+	case strings.HasPrefix(stream, greaderLabelPrefix):
+		return "list_contains(tags, ?)", []any{strings.TrimPrefix(stream, greaderLabelPrefix)}, nil
+	case strings.HasPrefix(stream, greaderFeedPrefix):
+		return "feed = ?", []any{strings.TrimPrefix(stream, greaderFeedPrefix)}, nil
+	}
+	return "", nil, fmt.Errorf("%w: unknown stream %s", errInvalid, stream)
+}
+
+// A page of the articles in a stream, filtered with the parameters shared by stream/contents and stream/items/ids
+type greaderStream struct {
+	id          string
+	condition   string
+	args        []any
+	oldestFirst bool
+	count       int
+	offset      int
+}
+
+func greaderStreamFromRequest(r *http.Request, stream string, maxCount int) (greaderStream, error) {
+	condition, args, err := greaderStreamCondition(stream)
+	if err != nil {
+		return greaderStream{}, err
+	}
+	result := greaderStream{id: stream, condition: condition, args: args, count: greaderDefaultCount}
+
+	// xt excludes a stream, usually the read articles, and it only includes a stream
+	for _, excluded := range r.Form["xt"] {
+		condition, args, err := greaderStreamCondition(excluded)
+		if err != nil {
+			return greaderStream{}, err
+		}
+		result.condition += " AND NOT (" + condition + ")"
+		result.args = append(result.args, args...)
+	}
+	for _, included := range r.Form["it"] {
+		condition, args, err := greaderStreamCondition(included)
+		if err != nil {
+			return greaderStream{}, err
+		}
+		result.condition += " AND (" + condition + ")"
+		result.args = append(result.args, args...)
+	}
+
+	// ot and nt are the oldest and newest times in seconds
+	if value := r.Form.Get("ot"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return greaderStream{}, fmt.Errorf("%w: invalid ot %q", errInvalid, value)
+		}
+		result.condition += " AND pubdate >= ?"
+		result.args = append(result.args, time.Unix(seconds, 0).UTC())
+	}
+	if value := r.Form.Get("nt"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return greaderStream{}, fmt.Errorf("%w: invalid nt %q", errInvalid, value)
+		}
+		result.condition += " AND pubdate <= ?"
+		result.args = append(result.args, time.Unix(seconds, 0).UTC())
+	}
+
+	result.oldestFirst = r.Form.Get("r") == "o"
+	if value := r.Form.Get("n"); value != "" {
+		result.count, err = strconv.Atoi(value)
+		if err != nil || result.count < 1 {
+			return greaderStream{}, fmt.Errorf("%w: invalid n %q", errInvalid, value)
+		}
+		result.count = min(result.count, maxCount)
+	}
+	// the continuation is just the offset of the next page
+	if value := r.Form.Get("c"); value != "" {
+		result.offset, err = strconv.Atoi(value)
+		if err != nil || result.offset < 0 {
+			return greaderStream{}, fmt.Errorf("%w: invalid continuation %q", errInvalid, value)
+		}
+	}
+	return result, nil
+}
+
+// Gets the page of a user's item refs and the continuation for the next page, empty on the last page
+func (stream greaderStream) refs(userId int64) ([]greaderItemRef, string, error) {
+	// get one extra item to know if there is a next page
+	refs, err := greaderItemRefsDb(userId, stream.condition, stream.args, stream.oldestFirst, stream.count+1, stream.offset)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(refs) > stream.count {
+		return refs[:stream.count], strconv.Itoa(stream.offset + stream.count), nil
+	}
+	return refs, "", nil
+}
+
+// Gets the stream from the path of stream/contents, or the s parameter
+func greaderStreamId(r *http.Request) string {
+	if stream := r.PathValue("stream"); stream != "" {
+		return stream
+	}
+	if stream := r.Form.Get("s"); stream != "" {
+		return stream
+	}
+	return greaderReadingList
+}
+
+func greaderStreamItemIds(w http.ResponseWriter, r *http.Request) {
+	stream, err := greaderStreamFromRequest(r, greaderStreamId(r), greaderMaxIds)
+	if err != nil {
+		writeServiceError(w, r, "invalid stream", err)
+		return
+	}
+	refs, continuation, err := stream.refs(currentUser(r).Id)
+	if err != nil {
+		writeServiceError(w, r, "failed to get stream items", err)
+		return
+	}
+
+	type itemRef struct {
+		Id              string   `json:"id"`
+		DirectStreamIds []string `json:"directStreamIds"`
+		TimestampUsec   string   `json:"timestampUsec"`
+	}
+	itemRefs := []itemRef{}
+	for _, ref := range refs {
+		itemRefs = append(itemRefs, itemRef{strconv.FormatInt(ref.Id, 10), []string{}, strconv.FormatInt(ref.Date.UnixMicro(), 10)})
+	}
+	response := map[string]any{"itemRefs": itemRefs}
+	if continuation != "" {
+		response["continuation"] = continuation
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func greaderStreamContents(w http.ResponseWriter, r *http.Request) {
+	stream, err := greaderStreamFromRequest(r, greaderStreamId(r), greaderMaxItems)
+	if err != nil {
+		writeServiceError(w, r, "invalid stream", err)
+		return
+	}
+	refs, continuation, err := stream.refs(currentUser(r).Id)
+	if err != nil {
+		writeServiceError(w, r, "failed to get stream items", err)
+		return
+	}
+	ids := make([]int64, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.Id
+	}
+	writeGreaderItems(w, r, stream.id, ids, continuation)
+}
+
+// Gets specific items, by their i parameters
+func greaderItemContents(w http.ResponseWriter, r *http.Request) {
+	ids, err := greaderItemIds(r.Form["i"])
+	if err != nil {
+		writeServiceError(w, r, "invalid item ids", err)
+		return
+	}
+	if len(ids) > greaderMaxItems {
+		writeServiceError(w, r, "too many items", fmt.Errorf("%w: at most %d items can be requested", errInvalid, greaderMaxItems))
+		return
+	}
+	writeGreaderItems(w, r, greaderReadingList, ids, "")
+}
+
+// Parses item ids in either the long form with the id in hex or the short form in decimal
+func greaderItemIds(values []string) ([]int64, error) {
+	var ids []int64
+	for _, value := range values {
+		var id int64
+		var err error
+		if hexId, found := strings.CutPrefix(value, greaderItemPrefix); found {
+			var unsigned uint64
+			unsigned, err = strconv.ParseUint(hexId, 16, 64)
+			id = int64(unsigned)
+		} else {
+			id, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid item id %q", errInvalid, value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func writeGreaderItems(w http.ResponseWriter, r *http.Request, stream string, ids []int64, continuation string) {
+	items, err := greaderItemsDb(currentUser(r).Id, ids)
+	if err != nil {
+		writeServiceError(w, r, "failed to get items", err)
+		return
+	}
+
+	type link struct {
+		Href string `json:"href"`
+		Type string `json:"type,omitempty"`
+	}
+	type content struct {
+		Direction string `json:"direction"`
+		Content   string `json:"content"`
+	}
+	type origin struct {
+		StreamId string `json:"streamId"`
+		Title    string `json:"title"`
+		HtmlUrl  string `json:"htmlUrl"`
+	}
+	type item struct {
+		Id            string   `json:"id"`
+		CrawlTimeMsec string   `json:"crawlTimeMsec"`
+		TimestampUsec string   `json:"timestampUsec"`
+		Published     int64    `json:"published"`
+		Updated       int64    `json:"updated"`
+		Title         string   `json:"title"`
+		Author        string   `json:"author"`
+		Canonical     []link   `json:"canonical"`
+		Alternate     []link   `json:"alternate"`
+		Categories    []string `json:"categories"`
+		Summary       content  `json:"summary"`
+		Origin        origin   `json:"origin"`
+	}
+
+	converted := []item{}
+	for _, i := range items {
+		categories := []string{greaderReadingList}
+		if i.Read {
+			categories = append(categories, greaderRead)
+		}
+		for _, tag := range i.Tags {
+			if tag == savedTag {
+				categories = append(categories, greaderStarred)
+			}
+			categories = append(categories, greaderLabelPrefix+tag)
+		}
+		source := origin{StreamId: greaderFeedPrefix + i.Feed, Title: i.FeedTitle}
+		if parsed, err := url.Parse(i.Feed); err == nil && i.Feed != "" {
+			source.HtmlUrl = parsed.Scheme + "://" + parsed.Host
+		} else if parsed, err := url.Parse(i.Url); err == nil {
+			// bookmarks aren't in a feed, so they come from their own website
+			source = origin{StreamId: greaderFeedPrefix + parsed.Scheme + "://" + parsed.Host, Title: parsed.Host, HtmlUrl: parsed.Scheme + "://" + parsed.Host}
+		}
+		converted = append(converted, item{
+			Id:            fmt.Sprintf("%s%016x", greaderItemPrefix, uint64(i.Id)),
+			CrawlTimeMsec: strconv.FormatInt(i.Date.UnixMilli(), 10),
+			TimestampUsec: strconv.FormatInt(i.Date.UnixMicro(), 10),
+			Published:     i.Date.Unix(),
+			Updated:       i.Date.Unix(),
+			Title:         i.Title,
+			Canonical:     []link{{Href: i.Url}},
+			Alternate:     []link{{Href: i.Url, Type: "text/html"}},
+			Categories:    categories,
+			Summary:       content{"ltr", i.Content},
+			Origin:        source,
+		})
+	}
+
+	response := map[string]any{
+		"direction": "ltr",
+		"id":        stream,
+		"updated":   time.Now().Unix(),
+		"items":     converted,
+	}
+	if continuation != "" {
+		response["continuation"] = continuation
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to write items", "err", err)
+	}
+}
+
+// Adds (a) and removes (r) states and labels on items (i), reading and starring are the states clients change
+func greaderEditTag(w http.ResponseWriter, r *http.Request) {
+	ids, err := greaderItemIds(r.Form["i"])
+	if err != nil {
+		writeServiceError(w, r, "invalid item ids", err)
+		return
+	}
+	urls, err := itemUrlsDb(currentUser(r).Id, ids)
+	if err != nil {
+		writeServiceError(w, r, "failed to get items", err)
+		return
+	}
+
+	for _, remove := range []bool{false, true} {
+		streams := r.Form["a"]
+		if remove {
+			streams = r.Form["r"]
+		}
+		for _, stream := range streams {
+			action, tag, err := greaderEditAction(greaderCanonicalStream(stream), remove)
+			if err != nil {
+				writeServiceError(w, r, "invalid tag", err)
+				return
+			}
+			_, err = bulkDb(currentUser(r).Id, urls, action, tag, "")
+			if err != nil {
+				writeServiceError(w, r, "failed to edit tags", err)
+				return
+			}
+			if action == "add_tag" {
+				for _, url := range urls {
+					apply_archive_policy(db, url)
+				}
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+// Gets the bulkDb action that adds or removes a stream from articles
+func greaderEditAction(stream string, remove bool) (string, string, error) {
+	switch {
+	case stream == greaderRead && !remove, stream == greaderKeptUnread && remove:
+		return "mark_read", "", nil
+	case stream == greaderRead && remove, stream == greaderKeptUnread && !remove:
+		return "mark_unread", "", nil
+	case stream == greaderStarred:
+		stream = greaderLabelPrefix + savedTag
+	}
+	tag, found := strings.CutPrefix(stream, greaderLabelPrefix)
+	if !found {
+		return "", "", fmt.Errorf("%w: can't edit %s", errInvalid, stream)
+	}
+	if remove {
+		return "remove_tag", tag, nil
+	}
+	err := validTagName(tag)
+	if err != nil {
+		return "", "", err
+	}
+	return "add_tag", tag, nil
+}
+
+// Marks everything in a stream (s) read, up to a time in microseconds (ts) so new articles the client hasn't seen stay unread
+func greaderMarkAllRead(w http.ResponseWriter, r *http.Request) {
+	condition, args, err := greaderStreamCondition(greaderStreamId(r))
+	if err != nil {
+		writeServiceError(w, r, "invalid stream", err)
+		return
+	}
+	before := time.Now()
+	if value := r.Form.Get("ts"); value != "" {
+		micros, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeServiceError(w, r, "invalid timestamp", fmt.Errorf("%w: invalid ts %q", errInvalid, value))
+			return
+		}
+		before = time.UnixMicro(micros)
+	}
+	changed, err := markStreamReadDb(currentUser(r).Id, condition, args, before.UTC())
+	if err != nil {
+		writeServiceError(w, r, "failed to mark stream read", err)
+		return
+	}
+	slog.DebugContext(r.Context(), "marked stream read", "stream", greaderStreamId(r), "changed", changed)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+func registerGreader(mux *http.ServeMux) {
+	mux.HandleFunc("/accounts/ClientLogin", greaderClientLogin)
+
+	mux.HandleFunc("GET /reader/api/0/token", greaderAuth(greaderEditToken))
+	mux.HandleFunc("GET /reader/api/0/user-info", greaderAuth(greaderUserInfo))
+	mux.HandleFunc("GET /reader/api/0/subscription/list", greaderAuth(greaderSubscriptions))
+	mux.HandleFunc("POST /reader/api/0/subscription/edit", greaderAuth(greaderEditSubscription))
+	mux.HandleFunc("GET /reader/api/0/tag/list", greaderAuth(greaderTags))
+	mux.HandleFunc("GET /reader/api/0/unread-count", greaderAuth(greaderUnreadCounts))
+	mux.HandleFunc("GET /reader/api/0/stream/items/ids", greaderAuth(greaderStreamItemIds))
+	mux.HandleFunc("/reader/api/0/stream/items/contents", greaderAuth(greaderItemContents))
+	mux.HandleFunc("GET /reader/api/0/stream/contents/{stream...}", greaderAuth(greaderStreamContents))
+	mux.HandleFunc("POST /reader/api/0/edit-tag", greaderAuth(greaderEditTag))
+	mux.HandleFunc("POST /reader/api/0/mark-all-as-read", greaderAuth(greaderMarkAllRead))
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testBlogFeed = "https://blog.example.org/feed.xml"
+	testNewsFeed = "https://news.example.net/rss"
+)
+
+// Replays a request from testdata/greader with the token put in, the body is everything after the headers
+func replayGreader(t *testing.T, mux *http.ServeMux, name string, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	contents, err := os.ReadFile(filepath.Join("testdata", "greader", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, body, _ := strings.Cut(strings.ReplaceAll(string(contents), "{{token}}", token), "\n\n")
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\n\n")))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	body = strings.TrimSuffix(body, "\n")
+	r.Body = io.NopCloser(strings.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func decodeGreader[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var response T
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+	return response
+}
+
+// Gets whether a user has read an article and its tags
+func readAndTags(t *testing.T, userId int64, article string) (bool, []string) {
+	t.Helper()
+	article = "https://blog.example.org/" + article
+	read, err := existsDb("SELECT read_at IS NOT NULL FROM user_articles WHERE user_id=? AND article=?", userId, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := existsDb("SELECT list_contains(tags, ?) FROM user_articles WHERE user_id=? AND article=?", savedTag, userId, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags {
+		return read, []string{savedTag}
+	}
+	return read, nil
+}
+
+// Adds two feeds both users are subscribed to, with item ids 1 to 3 in the blog and 4 in the news feed
+func seedGreader(t *testing.T, userIds ...int64) {
+	t.Helper()
+	for _, feed := range []string{testBlogFeed, testNewsFeed} {
+		err := addFeedDb(feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, userId := range userIds {
+			err = subscribeDb(userId, feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_, err := db.Exec("UPDATE feeds SET title='Example Blog' WHERE url=?", testBlogFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	articles := []struct{ url, title, feed string }{
+		{"https://blog.example.org/first", "First post", testBlogFeed},
+		{"https://blog.example.org/second", "Second post", testBlogFeed},
+		{"https://blog.example.org/third", "Third post", testBlogFeed},
+		{"https://news.example.net/story", "A story", testNewsFeed},
+	}
+	for i, a := range articles {
+		date := time.Date(2026, 1, i+1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		_, err = addArticleDb(Article{Url: a.url, Title: a.title, Date: date, Comments: []Comments{}}, a.feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGreaderReplay(t *testing.T) {
+	useTestDb(t)
+	alice, _ := testUser(t, "alice")
+	bob, _ := testUser(t, "bob")
+	seedGreader(t, alice.Id, bob.Id)
+	mux := http.NewServeMux()
+	registerGreader(mux)
+
+	w := replayGreader(t, mux, "reeder-clientlogin-wrong-password.http", "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Error=BadAuthentication") {
+		t.Fatalf("wrong password got status %d: %s", w.Code, w.Body.String())
+	}
+	w = replayGreader(t, mux, "netnewswire-subscription-list.http", "not-a-token")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Google-Bad-Token") != "true" {
+		t.Fatalf("bad token got status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = replayGreader(t, mux, "netnewswire-clientlogin.http", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login got status %d: %s", w.Code, w.Body.String())
+	}
+	var token string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if value, found := strings.CutPrefix(line, "Auth="); found {
+			token = value
+		}
+	}
+	if token == "" {
+		t.Fatalf("login has no Auth: %s", w.Body.String())
+	}
+
+	t.Run("subscription list", func(t *testing.T) {
+		type subscription struct {
+			Id, Title, Url, HtmlUrl string
+		}
+		response := decodeGreader[struct{ Subscriptions []subscription }](t, replayGreader(t, mux, "netnewswire-subscription-list.http", token))
+		slices.SortFunc(response.Subscriptions, func(a, b subscription) int { return strings.Compare(a.Id, b.Id) })
+		expected := []subscription{
+			{"feed/" + testBlogFeed, "Example Blog", testBlogFeed, "https://blog.example.org"},
+			// feeds without a title yet are shown by their URL
+			{"feed/" + testNewsFeed, testNewsFeed, testNewsFeed, "https://news.example.net"},
+		}
+		if !slices.Equal(response.Subscriptions, expected) {
+			t.Errorf("got subscriptions %+v, expected %+v", response.Subscriptions, expected)
+		}
+	})
+
+	t.Run("move to folder", func(t *testing.T) {
+		w := replayGreader(t, mux, "reeder-move-to-folder.http", token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+		type category struct{ Id, Label string }
+		type subscription struct {
+			Id         string
+			Categories []category
+		}
+		response := decodeGreader[struct{ Subscriptions []subscription }](t, replayGreader(t, mux, "netnewswire-subscription-list.http", token))
+		for _, s := range response.Subscriptions {
+			expected := []category{}
+			if s.Id == "feed/"+testBlogFeed {
+				expected = []category{{"user/-/label/Tech Blogs", "Tech Blogs"}}
+			}
+			if !slices.Equal(s.Categories, expected) {
+				t.Errorf("got categories %v for %s", s.Categories, s.Id)
+			}
+		}
+		for _, f := range feedsDb(bob.Id) {
+			if f.Folder != "" {
+				t.Errorf("moved %s to %q for another user", f.FeedUrl, f.Folder)
+			}
+		}
+	})
+
+	t.Run("stream contents of an escaped feed id", func(t *testing.T) {
+		type item struct {
+			Id         string
+			Title      string
+			Categories []string
+			Origin     struct{ StreamId, Title string }
+		}
+		response := decodeGreader[struct {
+			Id           string
+			Items        []item
+			Continuation string
+		}](t, replayGreader(t, mux, "reeder-stream-contents-feed.http", token))
+		if response.Id != "feed/"+testBlogFeed {
+			t.Errorf("got stream id %q", response.Id)
+		}
+		var ids, titles []string
+		for _, i := range response.Items {
+			ids = append(ids, i.Id)
+			titles = append(titles, i.Title)
+			if i.Origin.StreamId != "feed/"+testBlogFeed || i.Origin.Title != "Example Blog" {
+				t.Errorf("got origin %+v for %s", i.Origin, i.Id)
+			}
+			if !slices.Equal(i.Categories, []string{greaderReadingList}) {
+				t.Errorf("got categories %v for %s", i.Categories, i.Id)
+			}
+		}
+		// r=o is oldest first, and only the blog's articles are in its feed
+		expectedIds := []string{greaderItemPrefix + "0000000000000001", greaderItemPrefix + "0000000000000002"}
+		if !slices.Equal(ids, expectedIds) || !slices.Equal(titles, []string{"First post", "Second post"}) {
+			t.Errorf("got items %v %v", ids, titles)
+		}
+		if response.Continuation != "2" {
+			t.Errorf("got continuation %q, expected 2", response.Continuation)
+		}
+	})
+
+	type itemIds struct {
+		ItemRefs     []struct{ Id, TimestampUsec string }
+		Continuation string
+	}
+	refIds := func(response itemIds) []string {
+		var ids []string
+		for _, ref := range response.ItemRefs {
+			ids = append(ids, ref.Id)
+		}
+		return ids
+	}
+
+	t.Run("unread item ids with continuation", func(t *testing.T) {
+		response := decodeGreader[itemIds](t, replayGreader(t, mux, "netnewswire-unread-ids.http", token))
+		if ids := refIds(response); !slices.Equal(ids, []string{"4", "3"}) || response.Continuation != "2" {
+			t.Errorf("got first page %v continued at %q", ids, response.Continuation)
+		}
+		if response.ItemRefs[0].TimestampUsec != "1767528000000000" {
+			t.Errorf("got timestamp %s for item 4", response.ItemRefs[0].TimestampUsec)
+		}
+		response = decodeGreader[itemIds](t, replayGreader(t, mux, "netnewswire-unread-ids-continued.http", token))
+		if ids := refIds(response); !slices.Equal(ids, []string{"2", "1"}) || response.Continuation != "" {
+			t.Errorf("got last page %v continued at %q", ids, response.Continuation)
+		}
+	})
+
+	t.Run("edit tags", func(t *testing.T) {
+		w := replayGreader(t, mux, "netnewswire-mark-read.http", token)
+		if w.Code != http.StatusOK || w.Body.String() != "OK" {
+			t.Fatalf("mark read got status %d: %s", w.Code, w.Body.String())
+		}
+		for _, article := range []string{"first", "second"} {
+			if read, _ := readAndTags(t, alice.Id, article); !read {
+				t.Errorf("%s isn't read", article)
+			}
+			if read, _ := readAndTags(t, bob.Id, article); read {
+				t.Errorf("%s is read for another user", article)
+			}
+		}
+		// the read articles are left out of the unread ids now
+		response := decodeGreader[itemIds](t, replayGreader(t, mux, "netnewswire-unread-ids.http", token))
+		if ids := refIds(response); !slices.Equal(ids, []string{"4", "3"}) || response.Continuation != "" {
+			t.Errorf("got unread %v continued at %q", ids, response.Continuation)
+		}
+
+		w = replayGreader(t, mux, "reeder-star.http", token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("star got status %d: %s", w.Code, w.Body.String())
+		}
+		if read, tags := readAndTags(t, alice.Id, "third"); read || !slices.Equal(tags, []string{savedTag}) {
+			t.Errorf("starred article is read %v with tags %v", read, tags)
+		}
+		if _, tags := readAndTags(t, bob.Id, "third"); tags != nil {
+			t.Errorf("starred for another user with tags %v", tags)
+		}
+	})
+
+	t.Run("unread count", func(t *testing.T) {
+		type unreadCount struct {
+			Id                      string
+			Count                   int
+			NewestItemTimestampUsec string
+		}
+		response := decodeGreader[struct{ UnreadCounts []unreadCount }](t, replayGreader(t, mux, "netnewswire-unread-count.http", token))
+		counts := map[string]int{}
+		for _, count := range response.UnreadCounts {
+			counts[count.Id] = count.Count
+		}
+		expected := map[string]int{
+			greaderReadingList:            2,
+			"feed/" + testBlogFeed:        1,
+			"feed/" + testNewsFeed:        1,
+			greaderStarred:                1,
+			greaderLabelPrefix + savedTag: 1,
+		}
+		for id, count := range expected {
+			if counts[id] != count {
+				t.Errorf("got %d unread in %s, expected %d", counts[id], id, count)
+			}
+		}
+		if len(counts) != len(expected) {
+			t.Errorf("got unread counts %v, expected %v", counts, expected)
+		}
+	})
+
+	t.Run("remove tags", func(t *testing.T) {
+		w := replayGreader(t, mux, "reeder-mark-unread-unstar.http", token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("mark unread got status %d: %s", w.Code, w.Body.String())
+		}
+		if read, _ := readAndTags(t, alice.Id, "first"); !read {
+			t.Error("first isn't read anymore")
+		}
+		if read, _ := readAndTags(t, alice.Id, "second"); read {
+			t.Error("second is still read")
+		}
+		if _, tags := readAndTags(t, alice.Id, "third"); tags != nil {
+			t.Errorf("third still has tags %v", tags)
+		}
+	})
+}
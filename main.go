@@ -3,11 +3,13 @@ package main
 import (
 	"database/sql"
 	"embed"
+	"fmt"
 	"html/template"
 	"log"
 	"log/slog"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -21,6 +23,66 @@ type Article struct {
 	Date       string
 	Comments   []Comments
 	Tags       []string
+	Read       bool
+	// From the page's metadata, only known for bookmarks
+	Description string
+	// Empty unless the user wrote one when saving the article
+	Note string
+}
+
+// The archived copy of an article shown in the reader view
+type ArchivedCopy struct {
+	// One of "html", "text", "pdf" or "image"
+	Type string
+	Html template.HTML
+	// The contents of plain text and PDF archives, which are shown as is
+	Text           string
+	Byline         string
+	Published      string
+	Image          string
+	CapturedAt     string
+	ReadingMinutes int
+	// The stored original of PDFs and images, empty for web pages
+	Asset     string
+	AssetType string
+	AssetSize int64
+	Width     int
+	Height    int
+}
+
+// A distinct capture of an article in its archive history
+type ArchiveVersion struct {
+	Id       int64
+	Captured string
+	// The id of the version before this one, 0 if this is the first
+	Previous int64
+	Words    int
+}
+
+// The result of the last attempts to fetch an article's link
+type LinkHealth struct {
+	// "ok", "gone", "error" or empty if it has never been fetched
+	Status   string
+	Failures int
+	// The error or HTTP status of the last failure
+	LastError   string
+	LastChecked string
+	NextRetry   string
+}
+
+type ArticlePage struct {
+	Article Article
+	// nil if the article hasn't been archived
+	Archive  *ArchivedCopy
+	DeadLink bool
+	Link     LinkHealth
+	// One of "details", "reader" or "versions"
+	View     string
+	Versions []ArchiveVersion
+	// The versions being compared, nil if there isn't a comparison
+	From *ArchiveVersion
+	To   *ArchiveVersion
+	Diff []DiffLine
 }
 
 type Articles struct {
@@ -28,6 +90,148 @@ type Articles struct {
 	Articles     []Article
 }
 
+type Tag struct {
+	Name string
+	// Favorite tags get a button on the unread page
+	Favorite bool
+	// The number of articles with this tag
+	Count  int
+	Unread int
+	// When the tag was last added to an article, empty if never
+	LastUsed string
+}
+
+// A page of articles with a tag
+type TaggedArticles struct {
+	Tag      string
+	Articles []Article
+	// Page numbers for the pagination links, 0 if there isn't a page
+	Page     int
+	Previous int
+	Next     int
+}
+
+// An article in the read history
+type HistoryEntry struct {
+	Article Article
+	ReadAt  string
+	// The id of the dismissal that marked this article read, 0 if it wasn't dismissed from the unread page
+	DismissalId int64
+	// The tag added when dismissing, empty if the article was only marked read
+	Tag string
+}
+
+// A page of the read history
+type History struct {
+	Entries []HistoryEntry
+	// Page numbers for the pagination links, 0 if there isn't a page
+	Page     int
+	Previous int
+	Next     int
+}
+
+// A queued fetch of an article
+type Job struct {
+	Id int64
+	// "archive" or "refresh"
+	Kind    string
+	Article Article
+	// One of "pending", "running", "done" or "failed"
+	Status  string
+	Error   string
+	Created string
+	// Empty until the job has started or finished
+	Started  string
+	Finished string
+}
+
+// The jobs of one kind that haven't finished successfully
+type JobQueue struct {
+	Kind    string
+	Pending int
+	Running int
+	Failed  int
+	// The oldest jobs in the queue, running jobs first
+	Jobs []Job
+}
+
+// A rule deciding whether articles are archived
+type ArchiveRule struct {
+	Id int64
+	// One of "tag", "tagged", "feed", "domain" or "all"
+	Match string
+	// The tag, feed URL or domain matched, empty for "tagged" and "all"
+	Value string
+	// One of "immediately", "archive" or "never"
+	Action string
+	// The largest page or document in bytes that will be archived, 0 for the default limit
+	MaxSize int64
+}
+
+// The bookmark page
+type BookmarkPage struct {
+	// A javascript: link that saves the page it is clicked on, see save.html
+	Bookmarklet template.URL
+}
+
+// The page the bookmarklet opens, filled in with what it knows about the page being saved
+type SavePage struct {
+	Url   string
+	Title string
+	// Separated by commas or spaces
+	Tags string
+	Note string
+}
+
+// The settings page
+type Settings struct {
+	User  User
+	Rules []ArchiveRule
+	// Suggestions for the values of new rules
+	Feeds []string
+	Tags  []string
+	// Every account, only filled in for admins
+	Users []User
+}
+
+// A local account
+type User struct {
+	Id   int64
+	Name string
+	// Admins can manage other accounts
+	Admin bool
+	// Set when the request came with an API token instead of a session, Admin is only kept if the token has the admin scope
+	Token bool
+}
+
+// A personal API token, see tokens.go
+type ApiToken struct {
+	Id      int64
+	Name    string
+	Scopes  []string
+	Created string
+	// Empty if the token never expires
+	Expires  string
+	Expired  bool
+	LastUsed string
+}
+
+// The API tokens page
+type Tokens struct {
+	User   User
+	Tokens []ApiToken
+	// A token that was just created, which is the only time it is shown
+	Created string
+	Error   string
+}
+
+// The login page
+type Login struct {
+	Error string
+	// Where to go after logging in
+	Next string
+}
+
 type Comments struct {
 	// The URL of the comments
 	Url string
@@ -42,6 +246,8 @@ type feed struct {
 	FeedUrl     string
 	Title       string
 	Description string
+	// The folder the user put the feed in, empty if it isn't in one
+	Folder string
 }
 
 //go:embed static/*
@@ -52,6 +258,12 @@ var templates embed.FS
 
 var db *sql.DB
 
+// Whether to store the raw HTTP responses of archived pages, they take a lot of space but are included in WARC exports
+var keepResponses = os.Getenv("NAARUM_KEEP_RESPONSES") != ""
+
+// Articles with this tag are starred in Google Reader clients and saved in Fever clients
+var savedTag = envOr("NAARUM_SAVED_TAG", "favorite")
+
 // Page showing unread articles
 var mainTemplate *template.Template
 
@@ -67,6 +279,9 @@ var search_template *template.Template
 // Page showing an input to add bookmarks
 var bookmark_template *template.Template
 
+// Page the bookmarklet opens to save a page
+var saveTemplate *template.Template
+
 // API response for search results
 var searchResultsTemplate *template.Template
 
@@ -76,6 +291,33 @@ var feed_template *template.Template
 // component for a single article
 var articleComponentTemplate *template.Template
 
+// Page showing read articles in the order they were read
+var historyTemplate *template.Template
+
+// Page for managing tags
+var tagsTemplate *template.Template
+
+// Page showing the articles with a tag
+var tagTemplate *template.Template
+
+// API response with a toast message, optionally with buttons to undo dismissals
+var toastTemplate *template.Template
+
+// Page showing the archive and refresh queues
+var jobsTemplate *template.Template
+
+// API response with the progress of a single job
+var jobStatusTemplate *template.Template
+
+// Page for changing settings, like the archive rules
+var settingsTemplate *template.Template
+
+// Page for logging in
+var loginTemplate *template.Template
+
+// Page for managing personal API tokens
+var tokensTemplate *template.Template
+
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 	var err error
@@ -84,7 +326,17 @@ func main() {
 		panic(err)
 	}
 
-	mainTemplate, err = template.ParseFS(templates, "templates/index.html", "templates/articles.html", "templates/header.html")
+	// anything after the program name is a command, like create-admin
+	if len(os.Args) > 1 {
+		err = runCommand(os.Args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	mainTemplate, err = template.ParseFS(templates, "templates/index.html", "templates/articles.html", "templates/bulk-actions.html", "templates/header.html")
 	if err != nil {
 		panic(err)
 	}
@@ -99,7 +351,7 @@ func main() {
 		panic(err)
 	}
 
-	search_template, err = template.ParseFS(templates, "templates/search.html", "templates/header.html")
+	search_template, err = template.ParseFS(templates, "templates/search.html", "templates/bulk-actions.html", "templates/header.html")
 	if err != nil {
 		panic(err)
 	}
@@ -109,6 +361,11 @@ func main() {
 		panic(err)
 	}
 
+	saveTemplate, err = template.ParseFS(templates, "templates/save.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
 	searchResultsTemplate, err = template.ParseFS(templates, "templates/search-results.html", "templates/article-component.html")
 	if err != nil {
 		panic(err)
@@ -124,12 +381,59 @@ func main() {
 		panic(err)
 	}
 
+	historyTemplate, err = template.ParseFS(templates, "templates/history.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
+	tagsTemplate, err = template.ParseFS(templates, "templates/tags.html", "templates/tag-list.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
+	tagTemplate, err = template.ParseFS(templates, "templates/tag.html", "templates/article-component.html", "templates/bulk-actions.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
+	toastTemplate, err = template.ParseFS(templates, "templates/toast.html")
+	if err != nil {
+		panic(err)
+	}
+
+	jobsTemplate, err = template.ParseFS(templates, "templates/jobs.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
+	jobStatusTemplate, err = template.ParseFS(templates, "templates/job-status.html")
+	if err != nil {
+		panic(err)
+	}
+
+	settingsTemplate, err = template.ParseFS(templates, "templates/settings.html", "templates/archive-rules.html", "templates/users.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
+	loginTemplate, err = template.ParseFS(templates, "templates/login.html")
+	if err != nil {
+		panic(err)
+	}
+
+	tokensTemplate, err = template.ParseFS(templates, "templates/tokens.html", "templates/token-list.html", "templates/header.html")
+	if err != nil {
+		panic(err)
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("POST /api/mark_read", markRead)
 
 	mux.HandleFunc("POST /api/remove_feed/", removeFeed)
 
+	mux.HandleFunc("POST /api/feeds/folder", setFolder)
+
 	mux.HandleFunc("POST /api/add_tag/", addTag)
 
 	mux.HandleFunc("POST /api/add_tag_mark_read", addTagMarkRead)
@@ -140,8 +444,34 @@ func main() {
 
 	mux.HandleFunc("POST /api/add_bookmark", addBookmark)
 
+	mux.HandleFunc("POST /api/save", saveBookmarkHandler)
+
 	mux.HandleFunc("POST /api/import_bookmarks", importBookmarks)
 
+	mux.HandleFunc("POST /api/import_archive", importArchive)
+
+	mux.HandleFunc("POST /api/undo", undo)
+
+	mux.HandleFunc("POST /api/restore", restore)
+
+	mux.HandleFunc("POST /api/mark_unread", markUnread)
+
+	mux.HandleFunc("POST /api/tags/{action}", editTag)
+
+	mux.HandleFunc("POST /api/bulk/{action}", bulk)
+
+	mux.HandleFunc("POST /api/archive", archiveNow)
+
+	mux.HandleFunc("GET /api/jobs/{id}", jobStatus)
+
+	mux.HandleFunc("POST /api/jobs/{id}/retry", retryJob)
+
+	mux.HandleFunc("POST /api/archive_rules/{action}", editArchiveRule)
+
+	mux.HandleFunc("POST /api/users/add", addUser)
+
+	mux.HandleFunc("POST /api/tokens/{action}", editApiToken)
+
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			http.Redirect(w, r, "/unread", http.StatusTemporaryRedirect)
@@ -170,6 +500,36 @@ func main() {
 
 	mux.HandleFunc("/bookmark", bookmarkHandler)
 
+	mux.HandleFunc("GET /save", saveHandler)
+
+	mux.HandleFunc("/history", historyHandler)
+
+	mux.HandleFunc("GET /assets/{hash}", assetHandler)
+
+	mux.HandleFunc("GET /export/archive.warc.gz", exportArchive)
+
+	mux.HandleFunc("GET /export/bookmarks.html", exportBookmarks)
+
+	mux.HandleFunc("/tags", tagsHandler)
+
+	mux.HandleFunc("/tags/{tag}", tagHandler)
+
+	mux.HandleFunc("/jobs", jobsHandler)
+
+	mux.HandleFunc("/settings", settingsHandler)
+
+	mux.HandleFunc("/tokens", tokensHandler)
+
+	mux.HandleFunc("/login", loginHandler)
+
+	mux.HandleFunc("POST /logout", logoutHandler)
+
+	registerApiV1(mux)
+
+	registerGreader(mux)
+
+	mux.HandleFunc("/fever/", feverHandler)
+
 	go func() {
 		for {
 			update_feeds(db)
@@ -181,11 +541,24 @@ func main() {
 	go func() {
 		for {
 			archive_pages(db)
+			rearchive_pages(db)
 			slog.Info("archiving pages")
 			time.Sleep(60 * 60 * time.Second)
 		}
 	}()
 
+	go run_jobs(db)
+
+	go func() {
+		for {
+			check_links(db)
+			slog.Info("checking archived links")
+			time.Sleep(24 * 60 * 60 * time.Second)
+		}
+	}()
+
+	warnIfNoUsers()
+
 	slog.Info("server starting")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	log.Fatal(http.ListenAndServe(":8080", requireLogin(mux)))
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Replaces the database with an empty one for the duration of a test
+func useTestDb(t *testing.T) {
+	t.Helper()
+	testDb, err := openDb(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = testDb
+	t.Cleanup(func() {
+		testDb.Close()
+		db = previous
+	})
+}
+
+// Creates a user and logs them in, returning the user and their session token
+func testUser(t *testing.T, name string) (User, string) {
+	t.Helper()
+	err := createUser(name, "correct horse battery", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := authenticate(name, "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := startSession(user, "browser", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, session
+}
+
+// A handler that only records that the request got through
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+}
@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS link_status STRING;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS fetch_failures INTEGER DEFAULT 0;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS next_fetch TIMESTAMP;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS last_checked TIMESTAMP;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS last_error STRING;
+COMMIT;
+
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+-- any failure used to mark a link dead, so they get a chance to be retried
+UPDATE articles SET dead_link=false, fetch_failures=1, link_status='error' WHERE dead_link;
+UPDATE articles SET fetch_failures=0 WHERE fetch_failures IS NULL;
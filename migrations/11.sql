@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+-- "html", "text", "pdf" or "image"
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS archive_type STRING;
+-- hash of the original file in assets for archives that aren't HTML
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS archive_asset STRING;
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS height INTEGER;
+COMMIT;
+
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+UPDATE articles SET archive_type='html' WHERE archive IS NOT NULL;
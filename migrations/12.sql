@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+CREATE SEQUENCE IF NOT EXISTS job_id;
+
+CREATE TABLE IF NOT EXISTS jobs(
+    id INTEGER PRIMARY KEY DEFAULT nextval('job_id'),
+    -- "archive" for articles without an archive, "refresh" to archive them again
+    kind STRING NOT NULL,
+    article STRING NOT NULL,
+    -- "pending", "running", "done" or "failed"
+    status STRING NOT NULL,
+    -- why the job failed, NULL unless it did
+    error STRING,
+    created TIMESTAMP NOT NULL,
+    started TIMESTAMP,
+    finished TIMESTAMP
+);
+COMMIT;
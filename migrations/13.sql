@@ -0,0 +1,27 @@
+BEGIN TRANSACTION;
+-- the feed an article came from, NULL for bookmarks
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS feed STRING;
+
+CREATE SEQUENCE IF NOT EXISTS archive_rule_id;
+
+-- decides which articles are archived, the first rule that matches an article is used
+CREATE TABLE IF NOT EXISTS archive_rules(
+    id INTEGER PRIMARY KEY DEFAULT nextval('archive_rule_id'),
+    position INTEGER NOT NULL,
+    -- what the rule matches: "tag", "tagged", "feed", "domain" or "all"
+    match STRING NOT NULL,
+    -- the tag, feed URL or domain that is matched, empty for "tagged" and "all"
+    value STRING NOT NULL,
+    -- "immediately", "archive" or "never"
+    action STRING NOT NULL,
+    -- the largest page or document in bytes that will be archived, NULL for no limit beyond the default
+    max_size BIGINT
+);
+
+-- tagged articles were the only ones archived before there were rules
+INSERT INTO archive_rules(position, match, value, action) VALUES (0, 'tagged', '', 'archive');
+COMMIT;
+
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+-- only articles with comments remember their feed
+UPDATE articles SET feed=(SELECT min(feed) FROM comments WHERE article=url) WHERE feed IS NULL;
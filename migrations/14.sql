@@ -0,0 +1,7 @@
+CREATE SEQUENCE IF NOT EXISTS article_item_id;
+
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+-- the numeric id Google Reader clients use for articles, it is set from the sequence when articles are added
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS item_id BIGINT;
+
+UPDATE articles SET item_id=nextval('article_item_id') WHERE item_id IS NULL;
@@ -0,0 +1,7 @@
+CREATE SEQUENCE IF NOT EXISTS feed_id;
+
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+-- the numeric id Fever clients use for feeds, it is set from the sequence when feeds are added
+ALTER TABLE feeds ADD COLUMN IF NOT EXISTS feed_id BIGINT;
+
+UPDATE feeds SET feed_id=nextval('feed_id') WHERE feed_id IS NULL;
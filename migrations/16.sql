@@ -0,0 +1,24 @@
+BEGIN TRANSACTION;
+CREATE SEQUENCE IF NOT EXISTS user_id;
+
+CREATE TABLE IF NOT EXISTS users(
+    id INTEGER PRIMARY KEY DEFAULT nextval('user_id'),
+    name STRING NOT NULL UNIQUE,
+    -- bcrypt hash of the password
+    password_hash STRING NOT NULL,
+    -- the md5 of "name:password" that Fever clients log in with
+    fever_key STRING NOT NULL,
+    admin BOOL NOT NULL,
+    created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions(
+    -- the sha256 of the token given to the client, so the database can't be used to log in
+    token_hash STRING PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    -- "browser" for the session cookie, "greader" for Google Reader clients
+    kind STRING NOT NULL,
+    created TIMESTAMP NOT NULL,
+    expires TIMESTAMP NOT NULL
+);
+COMMIT;
@@ -0,0 +1,55 @@
+BEGIN TRANSACTION;
+-- articles and feeds are shared between users, what each user reads, tags and subscribes to isn't
+CREATE TABLE IF NOT EXISTS user_articles(
+    user_id INTEGER NOT NULL,
+    article STRING NOT NULL,
+    read_at TIMESTAMP,
+    tags STRING[] NOT NULL,
+    PRIMARY KEY (user_id, article)
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions(
+    user_id INTEGER NOT NULL,
+    feed STRING NOT NULL,
+    PRIMARY KEY (user_id, feed)
+);
+
+CREATE TABLE IF NOT EXISTS user_tags(
+    user_id INTEGER NOT NULL,
+    name STRING NOT NULL,
+    favorite BOOL NOT NULL,
+    position INTEGER,
+    last_used TIMESTAMP,
+    PRIMARY KEY (user_id, name)
+);
+
+-- everything from before there were several users goes to the first one. If there isn't one yet it goes to a
+-- user without a password, which create-admin takes over.
+INSERT INTO users(name, password_hash, fever_key, admin, created)
+    SELECT 'default', '', '', true, current_localtimestamp() WHERE NOT EXISTS (SELECT * FROM users);
+
+INSERT INTO user_articles SELECT (SELECT min(id) FROM users), url, read_at, coalesce(tags, []) FROM articles;
+INSERT INTO subscriptions SELECT (SELECT min(id) FROM users), url FROM feeds;
+INSERT INTO user_tags SELECT (SELECT min(id) FROM users), name, favorite, position, last_used FROM tags;
+
+-- DuckDB can't replay adding a column to a table with a sequence default, so dismissals are copied to a new table instead
+CREATE TABLE IF NOT EXISTS user_dismissals(
+    id INTEGER PRIMARY KEY DEFAULT nextval('dismissals_id'),
+    user_id INTEGER NOT NULL,
+    session STRING NOT NULL,
+    article STRING NOT NULL,
+    -- the tag added when the article was dismissed, NULL if it was only marked read
+    tag STRING,
+    dismissed TIMESTAMP NOT NULL
+);
+INSERT INTO user_dismissals SELECT id, (SELECT min(id) FROM users), session, article, tag, dismissed FROM dismissals;
+
+DROP TABLE tags;
+DROP TABLE dismissals;
+COMMIT;
+
+ALTER TABLE user_tags RENAME TO tags;
+ALTER TABLE user_dismissals RENAME TO dismissals;
+
+ALTER TABLE articles DROP COLUMN read_at;
+ALTER TABLE articles DROP COLUMN tags;
@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+CREATE SEQUENCE IF NOT EXISTS api_token_id;
+
+-- tokens scripts use to call the API as a user, sent as "Authorization: Bearer <token>"
+CREATE TABLE IF NOT EXISTS api_tokens(
+    id INTEGER PRIMARY KEY DEFAULT nextval('api_token_id'),
+    user_id INTEGER NOT NULL,
+    name STRING NOT NULL,
+    -- the sha256 of the token, which is only shown once when it is created
+    token_hash STRING NOT NULL UNIQUE,
+    -- "read" allows GET requests, "write" everything else, "admin" what only admins can do
+    scopes STRING[] NOT NULL,
+    created TIMESTAMP NOT NULL,
+    -- NULL if the token doesn't expire
+    expires TIMESTAMP,
+    last_used TIMESTAMP
+);
+COMMIT;
@@ -0,0 +1,2 @@
+-- what the user wrote about an article when saving it, like the text they had selected on the page
+ALTER TABLE user_articles ADD COLUMN note STRING;
@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+CREATE SEQUENCE IF NOT EXISTS dismissals_id;
+
+CREATE TABLE IF NOT EXISTS dismissals(
+    id INTEGER PRIMARY KEY DEFAULT nextval('dismissals_id'),
+    session STRING NOT NULL,
+    article STRING NOT NULL,
+    -- the tag added when the article was dismissed, NULL if it was only marked read
+    tag STRING,
+    dismissed TIMESTAMP NOT NULL
+);
+COMMIT;
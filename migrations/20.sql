@@ -0,0 +1,2 @@
+-- the summary from the page's metadata, saved with bookmarks
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS description STRING;
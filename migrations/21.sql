@@ -0,0 +1,2 @@
+-- a title the user gave an article when saving it, NULL to use the article's own
+ALTER TABLE user_articles ADD COLUMN title STRING;
@@ -0,0 +1,6 @@
+-- the feed articles a user deleted, so polling the feed again doesn't give them back
+CREATE TABLE IF NOT EXISTS deleted_articles(
+    user_id INTEGER NOT NULL,
+    article STRING NOT NULL,
+    PRIMARY KEY (user_id, article)
+);
@@ -0,0 +1,2 @@
+-- a hash of the robots.txt that didn't allow archiving the article, it is tried again once robots.txt changes
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS robots_hash STRING;
@@ -0,0 +1,2 @@
+-- the folder a user put a feed in, NULL if it isn't in one. Folders are only for organizing feeds, unlike tags.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS folder STRING;
@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS position INTEGER;
+
+-- these used to be hardcoded in the unread page
+INSERT OR IGNORE INTO tags VALUES ('later', true, 0), ('favorite', true, 1), ('reference', true, 2), ('archive', true, 3);
+
+INSERT OR IGNORE INTO tags SELECT DISTINCT unnest(tags), false, NULL FROM articles;
+COMMIT;
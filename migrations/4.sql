@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS last_used TIMESTAMP;
+
+-- we don't know when tags were actually added, the newest tagged article is the best guess
+UPDATE tags SET last_used=(SELECT max(pubdate) FROM articles WHERE list_contains(articles.tags, tags.name));
+COMMIT;
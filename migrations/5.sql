@@ -0,0 +1,7 @@
+-- DuckDB can't alter a table and then update it in the same transaction, so this runs without one
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS read_at TIMESTAMP;
+
+-- we only know when articles dismissed from the unread page were read, otherwise the publish date is the best guess
+UPDATE articles SET read_at=coalesce((SELECT max(dismissed) FROM dismissals WHERE article=url), pubdate, current_localtimestamp()) WHERE read;
+
+ALTER TABLE articles DROP COLUMN read;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+-- metadata found when archiving the article
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS byline STRING;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS published TIMESTAMP;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS image STRING;
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+-- images and other files referenced by archives, stored by the sha256 of their contents
+CREATE TABLE IF NOT EXISTS assets(
+    hash STRING NOT NULL PRIMARY KEY,
+    content_type STRING NOT NULL,
+    data BLOB NOT NULL,
+    source STRING NOT NULL,
+    created TIMESTAMP NOT NULL
+);
+COMMIT;
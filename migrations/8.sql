@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+-- the raw HTTP responses articles were archived from, only kept if NAARUM_KEEP_RESPONSES is set
+CREATE TABLE IF NOT EXISTS responses(
+    article STRING NOT NULL PRIMARY KEY,
+    raw BLOB NOT NULL,
+    fetched TIMESTAMP NOT NULL
+);
+COMMIT;
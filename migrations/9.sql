@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+-- the archive table was never used, it is replaced with one that keeps every version of an article
+DROP TABLE IF EXISTS archive;
+
+CREATE SEQUENCE IF NOT EXISTS archive_id;
+
+CREATE TABLE archive(
+    id INTEGER PRIMARY KEY DEFAULT nextval('archive_id'),
+    article STRING NOT NULL,
+    contents STRING NOT NULL,
+    -- sha256 of contents, used to skip storing identical copies
+    hash STRING NOT NULL,
+    captured TIMESTAMP NOT NULL
+);
+
+INSERT INTO archive(article, contents, hash, captured)
+    SELECT url, archive, sha256(archive), coalesce(archived_at, current_localtimestamp()) FROM articles WHERE archive IS NOT NULL;
+COMMIT;
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// What archive rules can match on, in the order they are offered on the settings page
+var archiveRuleMatches = []string{"tag", "tagged", "feed", "domain", "all"}
+
+// What archive rules can do with the articles they match
+var archiveRuleActions = []string{"immediately", "archive", "never"}
+
+// Whether a rule applies to an article from feed (empty for bookmarks) with tags
+func (rule ArchiveRule) matches(article_url string, feed string, tags []string) bool {
+	switch rule.Match {
+	case "tag":
+		return slices.Contains(tags, rule.Value)
+	case "tagged":
+		return len(tags) > 0
+	case "feed":
+		return feed == rule.Value
+	case "domain":
+		parsed, err := url.Parse(article_url)
+		if err != nil {
+			return false
+		}
+		// subdomains are included, so youtube.com also matches www.youtube.com
+		host := strings.ToLower(parsed.Hostname())
+		return host == rule.Value || strings.HasSuffix(host, "."+rule.Value)
+	case "all":
+		return true
+	}
+	return false
+}
+
+// The first rule that matches an article, nil if none do and the article shouldn't be archived
+func matchArchiveRule(rules []ArchiveRule, article_url string, feed string, tags []string) *ArchiveRule {
+	for i := range rules {
+		if rules[i].matches(article_url, feed, tags) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// The largest page or document that can be archived for an article
+func archiveSizeLimit(article_url string) int64 {
+	feed, tags, _, err := archivePolicyInputDb(article_url)
+	if err != nil {
+		return maxDocumentSize
+	}
+	rule := matchArchiveRule(archiveRulesDb(), article_url, feed, tags)
+	if rule == nil || rule.MaxSize <= 0 {
+		return maxDocumentSize
+	}
+	return min(rule.MaxSize, maxDocumentSize)
+}
+
+// Queues an article to be archived right away if it matches a rule that says to.
+// Called whenever an article is added or tagged.
+func apply_archive_policy(db *sql.DB, article_url string) {
+	feed, tags, archived, err := archivePolicyInputDb(article_url)
+	if err != nil {
+		slog.Error("unable to get article for archive rules", "url", article_url, "error", err)
+		return
+	}
+	if archived {
+		return
+	}
+	rule := matchArchiveRule(archiveRulesDb(), article_url, feed, tags)
+	if rule == nil || rule.Action != "immediately" {
+		return
+	}
+	_, err = queue_job(db, "archive", article_url)
+	if err != nil {
+		slog.Error("unable to queue article to be archived", "url", article_url, "error", err)
+	}
+}
+
+// The size limit in megabytes, as entered on the settings page
+func (rule ArchiveRule) MaxSizeMb() string {
+	return strconv.FormatFloat(float64(rule.MaxSize)/1000/1000, 'f', -1, 64)
+}
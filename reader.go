@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Average reading speed used for the reading time estimate
+const wordsPerMinute = 230
+
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// Archives come from arbitrary websites so anything that could run scripts or break out of the page is removed
+var archivePolicy = bluemonday.UGCPolicy().AddTargetBlankToFullyQualifiedLinks(true)
+
+// Renders an archived markdown copy of a page as HTML that is safe to include in our pages
+func renderArchive(markdown string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := markdownRenderer.Convert([]byte(markdown), &buf)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(archivePolicy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Estimates how many minutes it takes to read some text, at least 1
+func readingMinutes(text string) int {
+	minutes := (len(strings.Fields(text)) + wordsPerMinute - 1) / wordsPerMinute
+	return max(minutes, 1)
+}
+
+// Fills in what the reader view shows for the contents of an archive.
+// Markdown is rendered as HTML, plain text and the text of PDFs are shown as they are.
+func (archive *ArchivedCopy) setContents(contents string) error {
+	archive.ReadingMinutes = readingMinutes(contents)
+	if archive.Type == "text" || archive.Type == "pdf" {
+		archive.Text = contents
+		archive.Html = ""
+		return nil
+	}
+	html, err := renderArchive(contents)
+	if err != nil {
+		return err
+	}
+	archive.Html = html
+	return nil
+}
+
+// The size of the stored original in a human readable form, ie "1.2 MB"
+func (archive *ArchivedCopy) Size() string {
+	size := float64(archive.AssetSize)
+	for _, unit := range []string{"bytes", "kB", "MB"} {
+		if size < 1000 {
+			if unit == "bytes" {
+				return fmt.Sprintf("%d %s", archive.AssetSize, unit)
+			}
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1000
+	}
+	return fmt.Sprintf("%.1f GB", size)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// The operations shared by the htmx handlers and the JSON API. They validate their input and return errors
+// wrapping errNotFound or errInvalid so each handler can turn them into the right response.
+
+var errNotFound = errors.New("not found")
+var errInvalid = errors.New("invalid request")
+
+// Adds https:// to URLs typed without a scheme
+func normalizeUrl(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: missing url", errInvalid)
+	}
+	if !strings.HasPrefix(raw, "https://") && !strings.HasPrefix(raw, "http://") {
+		raw = "https://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("%w: invalid url %q", errInvalid, raw)
+	}
+	return raw, nil
+}
+
+// The different paths to look for a feed in when subscribing to a website
+var feedPaths = []string{"", "/rss", "/index.xml", "/feed"}
+
+// Finds the feed of a website and subscribes the user to it, adding it and fetching its articles if it is new
+func subscribeFeed(userId int64, site string) (feed, error) {
+	site, err := normalizeUrl(site)
+	if err != nil {
+		return feed{}, err
+	}
+
+	// TODO: parallelize this
+	for _, path := range feedPaths {
+		feedUrl := site + path
+		slog.Debug("trying path: " + feedUrl)
+		resp, err := httpClient.Get(feedUrl)
+		if err != nil {
+			slog.Debug("got err", "err", err.Error())
+			continue
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			slog.Debug("got status code", "status", resp.StatusCode)
+			continue
+		}
+		slog.Debug("got response on path: " + feedUrl)
+		parser := rss.Parser{}
+		_, err = parser.Parse(io.LimitReader(resp.Body, maxFeedSize))
+		resp.Body.Close()
+		if err != nil {
+			slog.Debug("unable to read feed body")
+			continue
+		}
+
+		// this is the correct feed URL
+		err = addFeedDb(feedUrl)
+		if err != nil {
+			return feed{}, err
+		}
+		err = subscribeDb(userId, feedUrl)
+		if err != nil {
+			return feed{}, err
+		}
+		update_feed(db, feedUrl)
+		return getFeedDb(feedUrl), nil
+	}
+	return feed{}, fmt.Errorf("%w: no feed found at %s", errInvalid, site)
+}
+
+func unsubscribeFeed(userId int64, feedUrl string) error {
+	exists, err := existsDb("SELECT count(*) > 0 FROM subscriptions WHERE user_id=? AND feed=?", userId, feedUrl)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: feed %s", errNotFound, feedUrl)
+	}
+	return unsubscribeDb(userId, feedUrl)
+}
+
+// Folder names are kept short, and can't have a / since they are also Google Reader label ids
+const maxFolderLength = 100
+
+// Puts a feed the user is subscribed to in a folder, an empty folder takes it out of the one it is in
+func setFeedFolder(userId int64, feedUrl string, folder string) error {
+	folder = strings.Join(strings.Fields(folder), " ")
+	if strings.Contains(folder, "/") || len(folder) > maxFolderLength {
+		return fmt.Errorf("%w: invalid folder name %q", errInvalid, folder)
+	}
+	exists, err := existsDb("SELECT count(*) > 0 FROM subscriptions WHERE user_id=? AND feed=?", userId, feedUrl)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: feed %s", errNotFound, feedUrl)
+	}
+	return setFolderDb(userId, feedUrl, folder)
+}
+
+func requireArticle(userId int64, article_url string) error {
+	exists, err := existsDb("SELECT count(*) > 0 FROM user_articles WHERE user_id=? AND article=?", userId, article_url)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: article %s", errNotFound, article_url)
+	}
+	return nil
+}
+
+func requireTag(userId int64, name string) error {
+	exists, err := existsDb("SELECT count(*) > 0 FROM tags WHERE user_id=? AND name=?", userId, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: tag %s", errNotFound, name)
+	}
+	return nil
+}
+
+func validTagName(name string) error {
+	if !tagNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: invalid tag name %q", errInvalid, name)
+	}
+	return nil
+}
+
+func getArticle(userId int64, article_url string) (Article, error) {
+	err := requireArticle(userId, article_url)
+	if err != nil {
+		return Article{}, err
+	}
+	return getArticleDb(userId, article_url), nil
+}
+
+// Gets a page of articles, see articlesDb. Returns whether there is another page after this one.
+func listArticles(userId int64, unread bool, tag string, page int, pageSize int) ([]Article, bool) {
+	// get one extra article to know if there is a next page
+	articles := articlesDb(userId, unread, tag, pageSize+1, (page-1)*pageSize)
+	if len(articles) > pageSize {
+		return articles[:pageSize], true
+	}
+	return articles, false
+}
+
+// Searches titles, archives and tags (with #tag), returning a page of results and whether there are more
+func searchArticles(userId int64, query string, page int, pageSize int) ([]Article, bool, error) {
+	_, err := conditionFromQuery(query)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	articles := queryArticlesDb(userId, query)
+	start := min((page-1)*pageSize, len(articles))
+	end := min(start+pageSize, len(articles))
+	return articles[start:end], end < len(articles), nil
+}
+
+func setArticleRead(userId int64, article_url string, read bool) error {
+	err := requireArticle(userId, article_url)
+	if err != nil {
+		return err
+	}
+	if read {
+		markReadDb(userId, article_url)
+		return nil
+	}
+	return markUnreadDb(userId, article_url)
+}
+
+func tagArticle(userId int64, article_url string, tag string) error {
+	err := validTagName(tag)
+	if err != nil {
+		return err
+	}
+	err = requireArticle(userId, article_url)
+	if err != nil {
+		return err
+	}
+	addTagDb(userId, article_url, tag)
+	apply_archive_policy(db, article_url)
+	return nil
+}
+
+func untagArticle(userId int64, article_url string, tag string) error {
+	err := requireArticle(userId, article_url)
+	if err != nil {
+		return err
+	}
+	removeTagDb(userId, article_url, tag)
+	return nil
+}
+
+func createTag(userId int64, name string, favorite bool) error {
+	err := validTagName(name)
+	if err != nil {
+		return err
+	}
+	exists, err := existsDb("SELECT count(*) > 0 FROM tags WHERE user_id=? AND name=?", userId, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: tag %s already exists", errInvalid, name)
+	}
+	return createTagDb(userId, name, favorite)
+}
+
+func renameTag(userId int64, name string, newName string) error {
+	err := validTagName(newName)
+	if err != nil {
+		return err
+	}
+	err = requireTag(userId, name)
+	if err != nil {
+		return err
+	}
+	return renameTagDb(userId, name, newName)
+}
+
+func mergeTag(userId int64, name string, into string) error {
+	if name == into {
+		return fmt.Errorf("%w: can't merge tag %s into itself", errInvalid, name)
+	}
+	err := requireTag(userId, name)
+	if err != nil {
+		return err
+	}
+	err = requireTag(userId, into)
+	if err != nil {
+		return err
+	}
+	return mergeTagDb(userId, name, into)
+}
+
+func deleteTag(userId int64, name string) error {
+	err := requireTag(userId, name)
+	if err != nil {
+		return err
+	}
+	return deleteTagDb(userId, name)
+}
+
+func setTagFavorite(userId int64, name string, favorite bool) error {
+	err := requireTag(userId, name)
+	if err != nil {
+		return err
+	}
+	return setTagFavoriteDb(userId, name, favorite)
+}
+
+// Adds a page as one of the user's bookmarks, using the page's title. Returns the bookmark and whether it was new to the user.
+func addBookmarkUrl(userId int64, page string) (Article, bool, error) {
+	return saveBookmark(userId, page, "", nil, nil, "")
+}
+
+// Adds a page as one of the user's bookmarks with a title, tags and a note, which can all be empty. The page is only
+// fetched for its metadata when its HTML isn't given, like when the bookmarklet couldn't send what the browser has.
+// Articles are shared, so the title is kept for just this user and HTML from the browser is only used for the
+// article's metadata when nobody had the article yet. Archiving the page still fetches it.
+// Returns the bookmark and whether it was new to the user.
+func saveBookmark(userId int64, page string, title string, html []byte, tags []string, note string) (Article, bool, error) {
+	page, err := normalizeUrl(page)
+	if err != nil {
+		return Article{}, false, err
+	}
+	for _, tag := range tags {
+		err = validTagName(tag)
+		if err != nil {
+			return Article{}, false, err
+		}
+	}
+	slog.Debug("adding bookmark", "url", page)
+
+	// HTML from the browser has already been decoded, whatever charset the page declares
+	contentType := "text/html; charset=utf-8"
+	fromBrowser := html != nil
+	if html == nil {
+		resp, err := httpClient.Get(page)
+		if err != nil {
+			return Article{}, false, fmt.Errorf("%w: failed to get bookmark website: %v", errInvalid, err)
+		}
+		defer resp.Body.Close()
+		html, err = readLimited(resp.Body, maxPageSize)
+		if err != nil {
+			return Article{}, false, fmt.Errorf("failed to read bookmark website: %v", err)
+		}
+		contentType = resp.Header.Get("Content-Type")
+	}
+
+	// documents like PDFs are bookmarked by their URL
+	var metadata Extracted
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		metadata, err = extractPageMetadata(html, contentType, page)
+		if err != nil {
+			slog.Warn("unable to read bookmark metadata", "url", page, "error", err)
+		}
+	}
+	slog.Debug("extracted from bookmark", "title", metadata.Title, "description", metadata.Description, "byline", metadata.Byline)
+
+	article := Article{
+		Url:        page,
+		EscapedUrl: "",
+		Title:      firstNonEmpty(metadata.Title, page),
+		Date:       time.Now().Format(time.RFC3339),
+		Comments:   []Comments{},
+		Tags:       []string{"bookmark"},
+	}
+	isNew, err := addArticleDb(article, "")
+	if err != nil {
+		return Article{}, false, err
+	}
+	if isNew || !fromBrowser {
+		err = setArticleMetadataDb(page, metadata)
+		if err != nil {
+			return Article{}, false, err
+		}
+	}
+	added, err := addUserArticleDb(userId, page)
+	if err != nil {
+		return Article{}, false, err
+	}
+	err = setUserTitleDb(userId, page, strings.TrimSpace(title))
+	if err != nil {
+		return Article{}, false, err
+	}
+	for _, tag := range tags {
+		addTagDb(userId, page, tag)
+	}
+	note = strings.TrimSpace(note)
+	if note != "" {
+		err = setNoteDb(userId, page, note)
+		if err != nil {
+			return Article{}, false, err
+		}
+	}
+	if added || len(tags) > 0 {
+		apply_archive_policy(db, page)
+	}
+	return getArticleDb(userId, page), added, nil
+}
@@ -1,5 +1,18 @@
 async function mark_as_read(event, url) {
-    let response = await fetch("/api/mark_read", {method: "POST", body: url})
+    let response = await fetch("/api/mark_read", {method: "POST", body: url, headers: JSON.parse(document.body.getAttribute("hx-headers"))})
     console.log(event.target.closest('button').parentElement.parentElement)
     event.target.closest('button').parentElement.parentElement.remove()
-}
\ No newline at end of file
+}
+
+// Reloads the list of articles after a bulk action so it shows what changed
+document.addEventListener("htmx:afterRequest", (event) => {
+    if (!event.detail.successful || !event.detail.pathInfo.requestPath.startsWith("/api/bulk/")) {
+        return
+    }
+    const search = document.querySelector("#search-form")
+    if (search) {
+        htmx.trigger(search, "submit")
+        return
+    }
+    htmx.ajax("GET", location.href, {target: "main", select: "main", swap: "outerHTML"})
+})
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Personal API tokens let scripts call the API without logging in. They are sent as "Authorization: Bearer <token>"
+// on any /api/ route, and only allow what their scopes do.
+
+// Makes tokens easy to recognize, like when they are accidentally committed somewhere
+const apiTokenPrefix = "naarum_"
+
+// "read" allows GET requests, "write" every other request, and "admin" both as well as what only admins can do
+var apiTokenScopes = []string{"read", "write", "admin"}
+
+func createApiToken(user User, name string, scopes []string, days int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: tokens need a name", errInvalid)
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("%w: tokens need at least one scope", errInvalid)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			return "", fmt.Errorf("%w: unknown scope %q", errInvalid, scope)
+		}
+		if scope == "admin" && !user.Admin {
+			return "", fmt.Errorf("%w: only admins can create tokens with the admin scope", errInvalid)
+		}
+	}
+	if days < 0 {
+		return "", fmt.Errorf("%w: invalid expiry", errInvalid)
+	}
+
+	token := apiTokenPrefix + newToken()
+	err := createApiTokenDb(user.Id, name, hashToken(token), scopes, days)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func revokeApiToken(userId int64, id int64) error {
+	exists, err := existsDb("SELECT count(*) > 0 FROM api_tokens WHERE user_id=? AND id=?", userId, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: token %d", errNotFound, id)
+	}
+	return revokeApiTokenDb(userId, id)
+}
+
+// Whether a token with the given scopes can make a request
+func tokenAllows(scopes []string, r *http.Request) bool {
+	if slices.Contains(scopes, "admin") {
+		return true
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return slices.Contains(scopes, "read")
+	}
+	return slices.Contains(scopes, "write")
+}
+
+// Serves an API request made with a token as the token's user, see requireLogin
+func serveWithApiToken(next http.Handler, w http.ResponseWriter, r *http.Request, token string) {
+	user, scopes, err := apiTokenUserDb(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAuthError(w, r, http.StatusUnauthorized, "unauthorized", "the token is invalid, expired or revoked")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check API token", "err", err)
+		writeAuthError(w, r, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if !tokenAllows(scopes, r) {
+		writeAuthError(w, r, http.StatusForbidden, "forbidden", "the token's scopes don't allow "+r.Method+" requests")
+		return
+	}
+
+	user.Admin = user.Admin && slices.Contains(scopes, "admin")
+	user.Token = true
+	next.ServeHTTP(w, withUser(r, user))
+}
+
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	tokens, err := apiTokensDb(user.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get API tokens", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = tokensTemplate.Execute(w, Tokens{User: user, Tokens: tokens})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// Creates and revokes tokens, responding with the updated list of tokens
+func editApiToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	// otherwise a read only token could make itself a token that can do more
+	if user.Token {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("tokens can't be managed with a token"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	parsed, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	page := Tokens{User: user}
+	switch r.PathValue("action") {
+	case "create":
+		var days int
+		days, err = strconv.Atoi(parsed.Get("days"))
+		if err != nil {
+			err = fmt.Errorf("%w: invalid expiry %q", errInvalid, parsed.Get("days"))
+			break
+		}
+		page.Created, err = createApiToken(user, parsed.Get("name"), parsed["scope"], days)
+		if err == nil {
+			slog.InfoContext(r.Context(), "created API token", "user", user.Name, "name", parsed.Get("name"), "scopes", parsed["scope"])
+		}
+	case "revoke":
+		var id int64
+		id, err = strconv.ParseInt(parsed.Get("id"), 10, 64)
+		if err != nil {
+			err = fmt.Errorf("%w: invalid id", errInvalid)
+			break
+		}
+		err = revokeApiToken(user.Id, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown token action"))
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, "failed to edit API token", err)
+		return
+	}
+
+	page.Tokens, err = apiTokensDb(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = tokensTemplate.ExecuteTemplate(w, "token-list.html", page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
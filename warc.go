@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A single WARC record, see https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/
+type warcRecord struct {
+	Type      string
+	TargetUri string
+	Date      time.Time
+	// The content type of the block
+	ContentType string
+	Block       []byte
+}
+
+// Writes a record as its own gzip member, which lets tools seek to individual records in a .warc.gz
+func writeWarcRecord(w io.Writer, record warcRecord) error {
+	gz := gzip.NewWriter(w)
+
+	digest := sha1.Sum(record.Block)
+	var header strings.Builder
+	header.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&header, "WARC-Type: %s\r\n", record.Type)
+	fmt.Fprintf(&header, "WARC-Record-ID: <urn:uuid:%s>\r\n", uuid.NewString())
+	fmt.Fprintf(&header, "WARC-Date: %s\r\n", record.Date.UTC().Format(time.RFC3339))
+	if record.TargetUri != "" {
+		fmt.Fprintf(&header, "WARC-Target-URI: %s\r\n", record.TargetUri)
+	}
+	fmt.Fprintf(&header, "WARC-Block-Digest: sha1:%s\r\n", base32.StdEncoding.EncodeToString(digest[:]))
+	fmt.Fprintf(&header, "Content-Type: %s\r\n", record.ContentType)
+	fmt.Fprintf(&header, "Content-Length: %d\r\n", len(record.Block))
+	header.WriteString("\r\n")
+
+	_, err := gz.Write([]byte(header.String()))
+	if err != nil {
+		return err
+	}
+	_, err = gz.Write(record.Block)
+	if err != nil {
+		return err
+	}
+	_, err = gz.Write([]byte("\r\n\r\n"))
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Reads the next record, returns io.EOF when there are no more records
+func readWarcRecord(r *bufio.Reader) (warcRecord, error) {
+	var record warcRecord
+
+	// records are separated by blank lines
+	version := ""
+	for version == "" {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			return record, io.EOF
+		} else if err != nil {
+			return record, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return record, fmt.Errorf("expected a WARC record, got: %q", version)
+	}
+
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return record, fmt.Errorf("unable to read WARC header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "warc-type":
+			record.Type = value
+		case "warc-target-uri":
+			// WARC 1.0 wrapped the URI in angle brackets
+			record.TargetUri = strings.Trim(value, "<>")
+		case "warc-date":
+			record.Date, _ = time.Parse(time.RFC3339, value)
+		case "content-type":
+			record.ContentType = value
+		case "content-length":
+			length, err = strconv.Atoi(value)
+			if err != nil {
+				return record, fmt.Errorf("invalid WARC Content-Length: %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return record, fmt.Errorf("WARC record is missing Content-Length")
+	}
+
+	record.Block = make([]byte, length)
+	_, err := io.ReadFull(r, record.Block)
+	if err != nil {
+		return record, fmt.Errorf("unable to read WARC block: %v", err)
+	}
+	return record, nil
+}
+
+// Streams the user's archived articles as WARC records. Articles with a raw response get a response record,
+// the rest get a resource record of the markdown or plain text archive. The images and PDFs of those archives are included as resource records.
+func exportArchive(w http.ResponseWriter, r *http.Request) {
+	userId := currentUser(r).Id
+	w.Header().Set("Content-Type", "application/warc")
+	w.Header().Set("Content-Disposition", `attachment; filename="archive.warc.gz"`)
+
+	info := "software: Naarum RSS Reader\r\nformat: WARC File Format 1.1\r\n"
+	err := writeWarcRecord(w, warcRecord{Type: "warcinfo", Date: time.Now(), ContentType: "application/warc-fields", Block: []byte(info)})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "unable to write warcinfo", "err", err)
+		return
+	}
+
+	rows, err := db.Query("SELECT url, archive, coalesce(archive_type, 'html'), archived_at, raw, fetched FROM "+userArticles+" LEFT JOIN responses ON article=url WHERE archive IS NOT NULL", userId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "unable to get archived articles", "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var article, markdown, archiveType string
+		var archivedAt, fetched sql.NullTime
+		var raw []byte
+		err = rows.Scan(&article, &markdown, &archiveType, &archivedAt, &raw, &fetched)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to scan archived article", "err", err)
+			return
+		}
+
+		record := warcRecord{
+			Type:        "resource",
+			TargetUri:   article,
+			Date:        archivedAt.Time,
+			ContentType: "text/markdown; charset=utf-8",
+			Block:       []byte(markdown),
+		}
+		if archiveType == "text" {
+			record.ContentType = "text/plain; charset=utf-8"
+		}
+		if raw == nil && archiveType == "pdf" {
+			// the PDF itself is exported with the assets
+			continue
+		}
+		if raw != nil {
+			record = warcRecord{
+				Type:        "response",
+				TargetUri:   article,
+				Date:        fetched.Time,
+				ContentType: "application/http; msgtype=response",
+				Block:       raw,
+			}
+		}
+		err = writeWarcRecord(w, record)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to write WARC record", "url", article, "err", err)
+			return
+		}
+	}
+
+	// assets are shared between archives, so only the ones the user's archives show are exported
+	assetRows, err := db.Query("SELECT source, content_type, data, created FROM assets WHERE EXISTS "+
+		"(SELECT * FROM "+userArticles+" WHERE archive_asset=hash OR image='/assets/'||hash OR contains(archive, '/assets/'||hash))", userId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "unable to get assets", "err", err)
+		return
+	}
+	defer assetRows.Close()
+	for assetRows.Next() {
+		var record warcRecord
+		record.Type = "resource"
+		err = assetRows.Scan(&record.TargetUri, &record.ContentType, &record.Block, &record.Date)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to scan asset", "err", err)
+			return
+		}
+		err = writeWarcRecord(w, record)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "unable to write WARC record", "url", record.TargetUri, "err", err)
+			return
+		}
+	}
+}
+
+// Fills in the archives of articles from an uploaded WARC file, gzipped or not.
+// Only records for the user's articles are used, other pages are ignored.
+func importArchive(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "request lacks Content-Type header", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	form, err := mr.ReadForm(4 * 1024 * 1024)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error parsing form", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer form.RemoveAll()
+	fileHeaders := form.File["file"]
+	if len(fileHeaders) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no file uploaded"))
+		return
+	}
+	file, err := fileHeaders[0].Open()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error opening form file", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer file.Close()
+
+	imported, err := importWarc(currentUser(r).Id, file)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error importing WARC file", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	fmt.Fprintf(w, "Imported archives for %d articles", imported)
+}
+
+// A page that will be archived once all the assets in the WARC file have been imported
+type warcPage struct {
+	article     string
+	contentType string
+	body        []byte
+	captured    time.Time
+	// the raw HTTP response, nil for resource records
+	raw []byte
+}
+
+// Archives are shared, so only the user's own articles are filled in, see importArchive
+func importWarc(userId int64, file io.Reader) (int, error) {
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(2)
+	if err != nil {
+		return 0, err
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, err
+		}
+		reader = bufio.NewReader(gz)
+	}
+
+	var pages []warcPage
+	for {
+		record, err := readWarcRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if record.TargetUri == "" || (record.Type != "response" && record.Type != "resource") {
+			continue
+		}
+
+		page := warcPage{article: record.TargetUri, contentType: record.ContentType, body: record.Block, captured: record.Date}
+		if record.Type == "response" {
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), nil)
+			if err != nil {
+				slog.Error("unable to parse WARC response", "url", record.TargetUri, "err", err)
+				continue
+			}
+			page.body, err = io.ReadAll(resp.Body)
+			if err != nil {
+				slog.Error("unable to read WARC response body", "url", record.TargetUri, "err", err)
+				continue
+			}
+			if resp.StatusCode != http.StatusOK {
+				continue
+			}
+			page.contentType = resp.Header.Get("Content-Type")
+			page.raw = record.Block
+		}
+		if page.captured.IsZero() {
+			page.captured = time.Now()
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(page.contentType)
+		if strings.HasPrefix(mediaType, "image/") {
+			// pages are archived last so they can use these instead of downloading the images again
+			_, err = addAssetDb(db, page.body, mediaType, record.TargetUri)
+			if err != nil {
+				slog.Error("unable to add asset from WARC", "url", record.TargetUri, "err", err)
+			}
+			continue
+		}
+		if mediaType == "text/html" || mediaType == "text/markdown" || mediaType == "text/plain" || mediaType == "application/pdf" {
+			pages = append(pages, page)
+		}
+	}
+
+	imported := 0
+	for _, page := range pages {
+		var exists bool
+		err = db.QueryRow("SELECT count(*) > 0 FROM user_articles WHERE user_id=? AND article=?", userId, page.article).Scan(&exists)
+		if err != nil {
+			return imported, err
+		}
+		if !exists {
+			continue
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(page.contentType)
+		switch mediaType {
+		case "text/markdown":
+			_, err = db.Exec("UPDATE articles SET archive=?, archive_type='html', archived_at=?, dead_link=FALSE WHERE url=?", string(page.body), page.captured, page.article)
+		case "text/html":
+			err = save_archive(db, page.article, page.article, page.contentType, page.body, page.captured)
+		default:
+			err = save_document(db, page.article, mediaType, page.contentType, page.body, page.captured)
+		}
+		if err != nil {
+			slog.Error("unable to import archive", "url", page.article, "err", err)
+			continue
+		}
+		if page.raw != nil {
+			_, err = db.Exec("INSERT OR REPLACE INTO responses VALUES (?, ?, ?)", page.article, page.raw, page.captured)
+			if err != nil {
+				slog.Error("unable to import raw response", "url", page.article, "err", err)
+			}
+		}
+		imported++
+	}
+	return imported, nil
+}